@@ -12,7 +12,15 @@ import (
 	"github.com/bhmj/pg-api/internal/pkg/auth"
 	"github.com/bhmj/pg-api/internal/pkg/config"
 	phttp "github.com/bhmj/pg-api/internal/pkg/http"
+	"github.com/bhmj/pg-api/internal/pkg/lifecycle"
 	"github.com/bhmj/pg-api/internal/pkg/log"
+	"github.com/bhmj/pg-api/internal/pkg/str"
+)
+
+const (
+	defaultHealthCheckPeriod = 5 // seconds
+	defaultHealthFailures    = 3
+	defaultShutdownDeadline  = 5 // seconds
 )
 
 // App ...
@@ -45,8 +53,11 @@ func (s *app) Run() {
 		}
 	}
 	ctx, cancel := context.WithCancel(context.Background())
+	// readiness depends on dependency checks added by the service
+	lc := lifecycle.New(srv, str.Icoalesce(s.cfg.Health.Failures, defaultHealthFailures), s.log)
+	srv.SetReport(func() interface{} { return lc.Report() })
 	// add handlers
-	svc, err := service.NewService(ctx, s.cfg, s.log, srv)
+	svc, err := service.NewService(ctx, s.cfg, s.log, lc)
 	if err != nil {
 		cancel()
 		s.log.L().Error(err)
@@ -54,14 +65,21 @@ func (s *app) Run() {
 	}
 	mainHandler := svc.MainHandler
 	fileHandler := svc.FileHandler
+	openAPIHandler := svc.OpenAPIHandler
+	jobHandler := svc.JobHandler
 	if v != nil {
 		mainHandler = v.Wrap(mainHandler)
 		fileHandler = v.Wrap(fileHandler)
+		openAPIHandler = v.Wrap(openAPIHandler)
+		jobHandler = v.Wrap(jobHandler)
 	}
 	srv.HandleFunc("/"+s.cfg.HTTP.Endpoint+"/", mainHandler)
 	srv.HandleFunc("/"+s.cfg.HTTP.Endpoint+"/file/", fileHandler)
+	srv.HandleFunc("/"+s.cfg.HTTP.Endpoint+"/openapi.json", openAPIHandler)
+	srv.HandleFunc("/"+s.cfg.HTTP.Endpoint+"/jobs/", jobHandler)
 	// run HTTP server
 	srv.Run(s.cfg.HTTP, s.log)
+	go lc.Run(ctx, time.Duration(str.Icoalesce(s.cfg.Health.CheckPeriod, defaultHealthCheckPeriod))*time.Second)
 	// signal processing
 	done := make(chan bool)
 	go func() {
@@ -70,8 +88,17 @@ func (s *app) Run() {
 		err := fmt.Errorf("%s", <-c)
 		s.log.L().Info("signal: ", err)
 		tim := time.Now()
-		cancel()       // query termination
-		srv.Shutdown() // server termination
+		lc.Shutdown() // readiness probe fails from now on
+		if s.cfg.Shutdown.PreStop > 0 {
+			// let load balancers notice
+			time.Sleep(time.Duration(s.cfg.Shutdown.PreStop) * time.Second)
+		}
+		deadline := time.Duration(str.Icoalesce(s.cfg.Shutdown.Deadline, defaultShutdownDeadline)) * time.Second
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), deadline)
+		srv.Shutdown(drainCtx) // stop accepting connections, wait for running requests
+		svc.Drain(drainCtx)    // wait for background jobs
+		drainCancel()
+		cancel() // background routines termination
 		s.log.L().Info("shutdown duration: ", time.Since(tim))
 		done <- true
 	}()
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// backgroundJobs tracks goroutines started during request processing
+type backgroundJobs struct {
+	mx       sync.Mutex
+	wg       sync.WaitGroup
+	next     int64
+	running  map[int64]backgroundJob
+	draining bool // no new jobs are started
+}
+
+type backgroundJob struct {
+	name    string
+	started time.Time
+}
+
+// background runs fn in a tracked goroutine. Returns false if the service is draining and fn is not run.
+func (s *service) background(name string, fn func()) bool {
+	jobs := &s.jobs
+	jobs.mx.Lock()
+	if jobs.draining {
+		jobs.mx.Unlock()
+		s.log.L().Errorf("background job rejected, the service is shutting down: %s", name)
+		return false
+	}
+	if jobs.running == nil {
+		jobs.running = make(map[int64]backgroundJob)
+	}
+	jobs.next++
+	id := jobs.next
+	jobs.running[id] = backgroundJob{name: name, started: time.Now()}
+	jobs.wg.Add(1)
+	jobs.mx.Unlock()
+
+	go func() {
+		defer func() {
+			jobs.mx.Lock()
+			delete(jobs.running, id)
+			jobs.mx.Unlock()
+			jobs.wg.Done()
+		}()
+		fn()
+	}()
+	return true
+}
+
+// Drain waits for background jobs until the context is done. Unfinished jobs are logged, new jobs are rejected.
+// Job queue workers stop taking new jobs, unfinished queued jobs are retried later by any instance.
+func (s *service) Drain(ctx context.Context) {
+	if s.queue != nil {
+		s.queue.Stop(ctx)
+	}
+	s.jobs.mx.Lock()
+	s.jobs.draining = true // no wg.Add during Wait
+	s.jobs.mx.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.jobs.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.log.L().Info("background jobs finished")
+		return
+	case <-ctx.Done():
+	}
+	s.jobs.mx.Lock()
+	defer s.jobs.mx.Unlock()
+	for _, job := range s.jobs.running {
+		s.log.L().Errorf("unfinished background job: %s, running for %s", job.name, time.Since(job.started))
+	}
+}
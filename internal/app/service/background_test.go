@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Drain(t *testing.T) {
+	s := newTestService(&config.Config{})
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	assert.True(t, s.background("slow", func() {
+		<-release
+		close(finished)
+	}))
+
+	drained := make(chan struct{})
+	go func() {
+		s.Drain(context.Background())
+		close(drained)
+	}()
+	// wait until draining is started
+	for draining := false; !draining; {
+		s.jobs.mx.Lock()
+		draining = s.jobs.draining
+		s.jobs.mx.Unlock()
+		runtime.Gosched()
+	}
+
+	// new work is refused
+	assert.False(t, s.background("late", func() { t.Error("the job is run while draining") }))
+
+	// in-flight work is waited for
+	select {
+	case <-drained:
+		t.Fatal("drained before the job is finished")
+	default:
+	}
+	close(release)
+	<-drained
+	select {
+	case <-finished:
+	default:
+		t.Error("drained before the job is finished")
+	}
+
+	// unfinished jobs are left when the context is done
+	s = newTestService(&config.Config{})
+	block := make(chan struct{})
+	defer close(block)
+	s.background("stuck", func() { <-block })
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Drain(ctx)
+}
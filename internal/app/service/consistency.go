@@ -0,0 +1,65 @@
+package service
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/bhmj/pg-api/internal/pkg/db"
+	"github.com/bhmj/pg-api/internal/pkg/str"
+)
+
+// Read-your-writes: after a write call the client receives primary's WAL position (LSN) as a token.
+// Reads carrying the token go to a replica which has replayed past it, or to the primary.
+// The token is self-contained so it works across pg-api instances.
+
+// readDB returns read database connection for the request
+func (s *service) readDB(r *http.Request, grp *dbGroup) *sql.DB {
+	if s.cfg.DBGroup.Consistency.Enable {
+		if lsn := s.readAfter(r); lsn > 0 {
+			return grp.dbr.DBAfter(r.Context(), lsn)
+		}
+	}
+	return grp.dbr.DB()
+}
+
+// readAfter returns WAL position passed by the client in header or cookie
+func (s *service) readAfter(r *http.Request) uint64 {
+	token := r.Header.Get(str.Scoalesce(s.cfg.DBGroup.Consistency.Header, defaultConsistencyHeader))
+	if token == "" && s.cfg.DBGroup.Consistency.Cookie != "" {
+		if cookie, err := r.Cookie(s.cfg.DBGroup.Consistency.Cookie); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return 0
+	}
+	lsn, err := db.ParseLSN(strings.TrimSpace(token))
+	if err != nil {
+		return 0
+	}
+	return lsn
+}
+
+// markWrite passes primary's current WAL position to the client after a write call
+func (s *service) markWrite(w http.ResponseWriter, primary *sql.DB) {
+	if !s.cfg.DBGroup.Consistency.Enable {
+		return
+	}
+	lsn, err := db.CurrentLSN(primary)
+	if err != nil {
+		s.log.L().Errorf("current LSN: %s", err.Error())
+		return
+	}
+	w.Header().Set(str.Scoalesce(s.cfg.DBGroup.Consistency.Header, defaultConsistencyHeader), lsn)
+	if s.cfg.DBGroup.Consistency.Cookie != "" {
+		ttl := str.Icoalesce(s.cfg.DBGroup.Consistency.TTL, defaultConsistencyTTL)
+		http.SetCookie(w, &http.Cookie{
+			Name:     s.cfg.DBGroup.Consistency.Cookie,
+			Value:    lsn,
+			Path:     "/" + s.cfg.HTTP.Endpoint + "/",
+			MaxAge:   ttl,
+			HttpOnly: true,
+		})
+	}
+}
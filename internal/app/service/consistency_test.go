@@ -0,0 +1,90 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReadYourWrites(t *testing.T) {
+	// the replica has replayed 0/100, the primary is at 0/200
+	testRowsDriver.reply(func(dsn string, query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "pg_current_wal_lsn()::text") && !strings.Contains(query, "pg_is_in_recovery"):
+			return []string{"lsn"}, [][]driver.Value{{"0/200"}}
+		case strings.Contains(query, "pg_last_wal_replay_lsn"):
+			return []string{"lsn"}, [][]driver.Value{{"0/100"}}
+		}
+		return []string{"result"}, [][]driver.Value{{`{"db":"` + dsn + `"}`}}
+	})
+	primary, _ := sql.Open("pgapi_rows", "primary")
+	defer primary.Close()
+	replica, _ := sql.Open("pgapi_rows", "replica")
+	defer replica.Close()
+
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = "api"
+	cfg.General.Convention = "CRUD"
+	cfg.DBGroup.Consistency.Enable = true
+	cfg.DBGroup.Consistency.Cookie = "lsn"
+	s := newTestService(cfg)
+	s.dbw = primary
+	s.dbr = db.NewPool(primary, map[string]*sql.DB{"r1": replica}, "", 0, s.log)
+	s.db = &dbGroup{dbr: s.dbr, dbw: primary, readSchema: "api", writeSchema: "api", readSource: catalogRead, writeSource: catalogRead}
+
+	call := func(method string, path string, set func(r *http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(`{}`))
+		if set != nil {
+			set(r)
+		}
+		w := httptest.NewRecorder()
+		s.MainHandler(w, r)
+		return w
+	}
+
+	// a write returns the token in the header and the cookie
+	w := call(http.MethodPost, "/api/v1/orders", nil)
+	assert.Equal(t, "0/200", w.Header().Get(defaultConsistencyHeader))
+	if cookies := w.Result().Cookies(); assert.Len(t, cookies, 1) {
+		assert.Equal(t, "lsn", cookies[0].Name)
+		assert.Equal(t, "0/200", cookies[0].Value)
+	}
+
+	// reads without the token go to the replica
+	w = call(http.MethodGet, "/api/v1/orders/1", nil)
+	assert.JSONEq(t, `{"db":"replica"}`, replyJSON(w))
+	// the replica is behind the token: the primary is read
+	w = call(http.MethodGet, "/api/v1/orders/1", func(r *http.Request) { r.Header.Set(defaultConsistencyHeader, "0/200") })
+	assert.JSONEq(t, `{"db":"primary"}`, replyJSON(w))
+	w = call(http.MethodGet, "/api/v1/orders/1", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "lsn", Value: "0/200"}) })
+	assert.JSONEq(t, `{"db":"primary"}`, replyJSON(w))
+	// the replica has caught up with the token
+	w = call(http.MethodGet, "/api/v1/orders/1", func(r *http.Request) { r.Header.Set(defaultConsistencyHeader, "0/100") })
+	assert.JSONEq(t, `{"db":"replica"}`, replyJSON(w))
+	// invalid token is ignored
+	w = call(http.MethodGet, "/api/v1/orders/1", func(r *http.Request) { r.Header.Set(defaultConsistencyHeader, "x") })
+	assert.JSONEq(t, `{"db":"replica"}`, replyJSON(w))
+
+	// the token is not passed with consistency disabled
+	cfg.DBGroup.Consistency.Enable = false
+	w = call(http.MethodPost, "/api/v1/orders", nil)
+	assert.Equal(t, "", w.Header().Get(defaultConsistencyHeader))
+	w = call(http.MethodGet, "/api/v1/orders/1", func(r *http.Request) { r.Header.Set(defaultConsistencyHeader, "0/200") })
+	assert.JSONEq(t, `{"db":"replica"}`, replyJSON(w))
+}
+
+// replyJSON returns JSON result from the response body (MainHandler appends request details to it)
+func replyJSON(w *httptest.ResponseRecorder) string {
+	var res json.RawMessage
+	json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&res)
+	return string(res)
+}
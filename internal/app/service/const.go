@@ -2,6 +2,7 @@ package service
 
 import (
 	"regexp"
+	"time"
 )
 
 // used regular expressions
@@ -9,9 +10,36 @@ var regexpMap = map[string]*regexp.Regexp{
 	"parseUrl":            regexp.MustCompile(`(?i)(\w+)(?:/(\d+)?)`),                            // (word/)
 	"extServiceName":      regexp.MustCompile(`^.+://[^/]+/([^/?]+(?:/[^/?]+)*)/?(?:\?[^?]*)?$`), // something://domain.com[/path/path]/[?some=params]
 	"splitExtServiceName": regexp.MustCompile(`\w+`),
-	"version":             regexp.MustCompile(`v(\d+)/`),
+	"urlKey":              regexp.MustCompile(`{(\$.+?)}`), // {$key} in external service URL
+	"percentX":            regexp.MustCompile(`%\d+`),      // %x in TransferFields.From: ForwardFields value
+	"version":             regexp.MustCompile(`^v(\d+)/`),
+	"versionSuffix":       regexp.MustCompile(`^(.+)_v(\d+)$`),
 }
 
+// function catalog reload period used until the first successful load
+const catalogRetryPeriod = 10 * time.Second
+
+// read replicas health check period, seconds
+const defaultReplicaCheckPeriod = 5
+
+// default external service call timeouts
+const (
+	defaultEnhanceTimeout    = 1 * time.Second  // synchronous pre-processing
+	defaultBackgroundTimeout = 60 * time.Second // finalize pre-processing and post-processing
+)
+
+// default number of Enhance steps run in parallel
+const defaultEnhanceThreads = 4
+
+// response header listing fields enriched by Enhance steps
+const enrichedHeader = "X-Enriched-Fields"
+
+// read-your-writes token defaults
+const (
+	defaultConsistencyHeader = "X-Read-After"
+	defaultConsistencyTTL    = 60
+)
+
 // procedure suffixes per method
 var suffixMap = map[string]string{
 	"HIT":    "hit",
@@ -41,3 +69,16 @@ var writeDB = map[string]bool{
 	"PATCH":  true,
 	"DELETE": true,
 }
+
+// methods carrying request body
+var hasBody = map[string]bool{
+	"POST":  true,
+	"PUT":   true,
+	"PATCH": true,
+}
+
+// function catalog sources of the default database group
+const (
+	catalogRead  = "read"
+	catalogWrite = "write"
+)
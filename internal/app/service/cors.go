@@ -2,6 +2,8 @@ package service
 
 import (
 	"net/http"
+
+	"github.com/bhmj/pg-api/internal/pkg/str"
 )
 
 func (s *service) allowCORS(w http.ResponseWriter) {
@@ -9,6 +11,15 @@ func (s *service) allowCORS(w http.ResponseWriter) {
 	if len(s.cfg.HTTP.AccessFiles) > 0 {
 		xAuth += ", X-Auth-Sign, X-Auth-ID"
 	}
+	if s.cfg.DBGroup.Consistency.Enable {
+		xAuth += ", " + str.Scoalesce(s.cfg.DBGroup.Consistency.Header, defaultConsistencyHeader)
+	}
+	if s.cfg.Jobs.Enable {
+		xAuth += ", " + str.Scoalesce(s.cfg.Jobs.CallbackHeader, defaultCallbackHeader)
+	}
+	if s.cfg.Tenants.Source == "header" {
+		xAuth += ", " + s.cfg.Tenants.Header
+	}
 	// CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
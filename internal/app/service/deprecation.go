@@ -0,0 +1,39 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/auth"
+)
+
+// signalDeprecation sets Deprecation, Sunset and Link headers for deprecated method versions
+// and counts such calls. Returns true if the method version is past its sunset date and should be gone.
+func (s *service) signalDeprecation(w http.ResponseWriter, r *http.Request, parsed *ParsedURL) (gone bool) {
+	d := &parsed.Deprecation
+	if !d.Covers(s.version) {
+		return false
+	}
+
+	if d.DateTime.IsZero() {
+		w.Header().Set("Deprecation", "true")
+	} else {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.DateTime.Unix(), 10))
+	}
+	if !d.SunsetTime.IsZero() {
+		w.Header().Set("Sunset", d.SunsetTime.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		w.Header().Add("Link", "<"+d.Link+">; rel=\"successor-version\"")
+	}
+
+	// callers are limited to the keys of AccessFiles (or "unknown"), see auth.Verifier
+	caller := auth.GetCaller(r.Context())
+	s.metrics.Deprecated(parsed.MethodPath, s.version, caller)
+	if s.cfg.LogLevel >= 2 { // warnings, verbose
+		s.log.L().Warnf("deprecated method version called: %s v%d by %s", parsed.MethodPath, s.version, caller)
+	}
+
+	return d.Gone && time.Now().After(d.SunsetTime)
+}
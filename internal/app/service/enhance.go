@@ -2,129 +2,345 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"regexp"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bhmj/jsonslice"
 	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/jpath"
+	"github.com/bhmj/pg-api/internal/pkg/steps"
+	"github.com/bhmj/pg-api/internal/pkg/str"
 )
 
-// enhanceData sequentially calls all external services specified in Enhance section
-// embedding answers into body using TransferFields mapping
-func (s *service) enhanceData(body []byte, enhance []config.Enhance, timeout time.Duration) []byte {
+// enhanceStep is a single external service call
+type enhanceStep struct {
+	enh        config.Enhance
+	source     []byte      // body the step reads from
+	headers    http.Header // incoming headers for HeadersToSend
+	cbName     string      // circuit breaker name
+	metricName string
+	start      time.Time
+	// result
+	data  []byte
+	flds  map[string]interface{}
+	items []elementResult // ForEach results per element
+	err   error
+}
+
+// enhanceError is returned by enhanceData when a step with OnError fail could not be done
+type enhanceError struct {
+	status  int
+	service string
+	reason  string
+}
+
+func (e *enhanceError) Error() string {
+	return fmt.Sprintf("enrichment failed: %s: %s", e.service, e.reason)
+}
+
+// apiError returns the error for the client
+func (e *enhanceError) apiError() *apiError {
+	return &apiError{Message: "enrichment failed", Details: map[string]string{"service": e.service, "reason": e.reason}}
+}
+
+// enhanceData calls all external services specified in Enhance section
+// embedding answers into body using TransferFields mapping.
+// Returns paths of the enriched fields; failed step with OnError fail stops the processing.
+// Steps which do not depend on each other run in parallel, their results are embedded in the order of definition.
+// in contains incoming headers referenced by HeadersToSend.
+// timeout is used for steps without their own Timeout, props.EnhanceBudget limits total time of all steps.
+func (s *service) enhanceData(body []byte, enhance []config.Enhance, in http.Header, timeout time.Duration, props config.MethodConfig) ([]byte, []string, *enhanceError) {
 
 	var obj interface{}
 	err := json.Unmarshal(body, &obj)
 	if err != nil {
-		return body
+		return body, nil, nil
+	}
+	switch obj.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return body, nil, nil // only JSON objects and arrays can be enriched
 	}
 
-	regexpPercentX, _ := regexp.Compile(`%\d+`)    // %x mask to substitute with source field value
-	regexpURLKey, _ := regexp.Compile(`{(\$.+?)}`) // Regexp for keys of `{$key}` view
-	vals := make(map[string][]byte, 5)             // Map of values from the body, indexed by keys
-
-next:
-	for _, enh := range enhance {
+	vals := make(map[string][]byte, 5) // Map of values from the body, indexed by keys
 
-		startTime := time.Now() // metric
+	var deadline time.Time
+	if props.EnhanceBudget > 0 {
+		deadline = time.Now().Add(time.Duration(props.EnhanceBudget) * time.Millisecond)
+	}
+	threads := str.Icoalesce(props.EnhanceThreads, defaultEnhanceThreads)
+	sem := make(chan struct{}, threads)
+	var enriched []string
 
+	for _, level := range steps.Plan(enhance) {
 		tmp, _ := json.Marshal(obj)
 
-		// List of keys in current URL: [["{$key}", "$key"], ...]
-		keys := regexpURLKey.FindAllStringSubmatch(enh.URL, -1)
+		// prepare steps of the level
+		var run []*enhanceStep
+		for _, i := range level {
+			if step := s.prepareStep(enhance[i], tmp, vals); step != nil {
+				step.headers = in
+				run = append(run, step)
+			}
+		}
 
-		// Replace all keys in URL with the corresponding values from the body
-		for i := 0; i < len(keys); i++ {
-			key := keys[i][1] // "$key"
-			if _, ok := vals[key]; !ok {
-				val, err := jsonslice.Get(tmp, key) // Get value from the body by key
-				if err != nil {
-					s.log.L().Errorf("jsonslice fail: %s: %s on %s", err.Error(), key, string(tmp), key)
-					continue next
+		// do external service calls
+		var wg sync.WaitGroup
+		for _, step := range run {
+			if step.err != nil {
+				continue // not prepared
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(step *enhanceStep) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				stepTimeout := timeout
+				if step.enh.Timeout > 0 {
+					stepTimeout = time.Duration(step.enh.Timeout) * time.Millisecond
 				}
-				if val[0] == '"' { // If val is in double quotes (json string) then get rid of quotes
-					val = val[1 : len(val)-1]
+				if step.enh.ForEach != "" {
+					step.items, step.err = s.callForEach(step, stepTimeout, deadline, threads)
+				} else {
+					step.data, step.flds, step.err = s.callExternal(step, stepTimeout, deadline)
 				}
-				vals[key] = val
-
-			}
-			enh.URL = strings.Replace(enh.URL, keys[i][0], string(vals[key]), -1)
+			}(step)
 		}
+		wg.Wait()
 
-		// do not execute preprocessing step if condition is not met
-		if enh.Condition != "" {
-			cond := "$[?(" + enh.Condition + ")]"
-			result, err := jsonslice.Get([]byte("["+string(tmp)+"]"), cond)
-			if err != nil {
-				s.log.L().Errorf("jsonslice condition fail: %s: %s on %s", err.Error(), cond, string(tmp))
+		// embed results in the order of definition
+		for _, step := range run {
+			enh, data, flds := step.enh, step.data, step.flds
+			if step.err != nil {
+				s.log.L().Errorf("queryExternal: %s", step.err.Error())
+				s.metrics.ExternalError(s.vpath, step.metricName, failureReason(step.err))
+				switch enh.OnError {
+				case config.OnErrorFail:
+					status := str.Icoalesce(enh.ErrorStatus, http.StatusBadGateway)
+					return body, enriched, &enhanceError{status: status, service: step.metricName, reason: failureReason(step.err)}
+				case config.OnErrorFallback:
+					obj = s.fallback(obj, enh)
+				}
 				continue
 			}
-			if string(result) == "[]" {
+			if s.cfg.LogLevel >= 2 { // warnings, verbose
+				s.log.L().Infof("queryExternal result: %s", string(data))
+			}
+
+			// embed result into body
+			var fields []string
+			if enh.ForEach != "" {
+				fields = s.embedElements(obj, step)
+			} else {
+				obj, fields = s.embed(obj, enh, data, flds)
+			}
+			enriched = append(enriched, fields...)
+
+			// write metrics for external service call
+			s.metrics.Score(s.method, s.vpath, step.metricName, step.start, nil)
+		}
+	}
+
+	body, _ = json.Marshal(obj)
+
+	return body, enriched, nil
+}
+
+// embed puts values from the service response into the document using TransferFields mapping.
+// Returns the document and paths of the embedded fields.
+func (s *service) embed(doc interface{}, enh config.Enhance, data []byte, flds map[string]interface{}) (interface{}, []string) {
+	var fields []string
+	for _, dst := range enh.TransferFields {
+		// set corresponding "%x" in jsonpath
+		for _, match := range regexpMap["percentX"].FindAllString(dst.From, -1) {
+			idx, _ := strconv.Atoi(strings.Replace(match, "%", "", -1))
+			dst.From = strings.Replace(dst.From, match, fmt.Sprintf("%v", flds[enh.ForwardFields[idx-1]]), -1)
+		}
+		// get value by jsonpath
+		var value interface{}
+		each := pairwise(dst) // Default value is set to every element
+		v, err := jsonslice.Get(data, dst.From)
+		if err != nil || len(v) == 0 || string(v) == "[]" && multiValued(dst.From) {
+			if dst.Default == nil {
+				if err != nil {
+					s.log.L().Errorf("jsonslice(\"%s\") : %s", dst.From, err.Error())
+				}
 				continue
 			}
+			value, each = clone(dst.Default), false
+		} else if err = json.Unmarshal(v, &value); err != nil {
+			s.log.L().Errorf("json.Unmarshal(\"%s\") : %s", string(v), err.Error())
+			continue
+		}
+		// embed value
+		if values, ok := value.([]interface{}); ok && each {
+			doc, err = jpath.SetEach(doc, dst.To, values, dst.Mode)
+		} else {
+			doc, err = jpath.Set(doc, dst.To, value, dst.Mode)
+		}
+		if err != nil {
+			s.log.L().Errorf("embed \"%s\" : %s", dst.To, err.Error())
+			continue
 		}
+		fields = append(fields, dst.To)
+	}
+	return doc, fields
+}
 
-		// generate service name for metrics from external service URL
-		serviceMetricName := "external"
-		submatches := regexpMap["extServiceName"].FindAllStringSubmatch(enh.URL, -1)
-		if submatches != nil {
-			// http://domain.com/api/v1/some/service?param=foo -> api/v1/some/service
-			serviceMetricName = submatches[0][1]
-			// Split extServiceName into substrings of [:word:] class symbols (a-zA-Z0-9_)
-			// api/v1/some/service -> ["api","v1","some","service"]
-			substrings := regexpMap["splitExtServiceName"].FindAllString(serviceMetricName, -1)
-			// Finally concatenate substrings with "_" separator into extServiceName
-			// ["api","v1","some","service"] -> api_v1_some_service
-			serviceMetricName = strings.Join(substrings, "_")
+// fallback embeds TransferFields Default values of the failed step (into every element for ForEach step)
+func (s *service) fallback(doc interface{}, enh config.Enhance) interface{} {
+	if enh.ForEach == "" {
+		return s.defaults(doc, enh)
+	}
+	if v, err := jpath.Get(doc, enh.ForEach); err == nil {
+		if elements, ok := v.([]interface{}); ok {
+			for i := range elements {
+				elements[i] = s.defaults(elements[i], enh)
+			}
 		}
+	}
+	return doc
+}
 
-		// do external service call
-		data, flds, err := s.queryExternal(enh, tmp, timeout)
-		if err != nil {
-			s.log.L().Errorf("queryExternal: %s", err.Error())
+// defaults embeds TransferFields Default values into the document
+func (s *service) defaults(doc interface{}, enh config.Enhance) interface{} {
+	for _, dst := range enh.TransferFields {
+		if dst.Default == nil {
 			continue
 		}
-		if s.cfg.LogLevel >= 2 { // warnings, verbose
-			s.log.L().Infof("queryExternal result: %s", string(data))
+		var err error
+		if doc, err = jpath.Set(doc, dst.To, clone(dst.Default), dst.Mode); err != nil {
+			s.log.L().Errorf("fallback \"%s\" : %s", dst.To, err.Error())
 		}
+	}
+	return doc
+}
+
+// clone returns a deep copy of a config value so that embedding can not modify it
+func clone(v interface{}) interface{} {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var res interface{}
+	if err = json.Unmarshal(buf, &res); err != nil {
+		return v
+	}
+	return res
+}
 
-		// embed result into body
-		for _, dst := range enh.TransferFields {
-			// set corresponding "%x" in jsonpath
-			for _, match := range regexpPercentX.FindAllString(dst.From, -1) {
-				idx, _ := strconv.Atoi(strings.Replace(match, "%", "", -1))
-				dst.From = strings.Replace(dst.From, match, fmt.Sprintf("%v", flds[enh.ForwardFields[idx-1]]), -1)
+// pairwise reports whether the values matched by [*] of From are set one by one into the elements matched by the wildcard of To
+func pairwise(dst config.TransferFields) bool {
+	return strings.Contains(dst.From, "[*]") && jpath.Wildcards(dst.To) == 1
+}
+
+// multiValued reports whether jsonpath may return several values (an array of matches)
+func multiValued(path string) bool {
+	return strings.ContainsAny(path, "*?:,") || strings.Contains(path, "..")
+}
+
+// incomingHeaders returns incoming headers referenced by HeadersToSend of the method steps
+func incomingHeaders(props config.MethodConfig, header http.Header) http.Header {
+	in := make(http.Header)
+	for _, enhance := range [][]config.Enhance{props.Enhance, props.Postproc} {
+		for _, enh := range enhance {
+			for _, h := range enh.HeadersToSend {
+				if values := header.Values(h.From); h.From != "" && len(values) > 0 {
+					in[http.CanonicalHeaderKey(h.From)] = values
+				}
 			}
-			// get value by jsonpath
-			v, err := jsonslice.Get(data, dst.From)
-			if err != nil {
-				s.log.L().Errorf("jsonslice(\"%s\") : %s", dst.From, err.Error())
-				continue
+		}
+	}
+	return in
+}
+
+// stageSteps returns Enhance steps of the stage
+func stageSteps(enhance []config.Enhance, stage string) []config.Enhance {
+	var res []config.Enhance
+	for _, enh := range enhance {
+		if str.Scoalesce(enh.Stage, config.StageRequest) == stage {
+			res = append(res, enh)
+		}
+	}
+	return res
+}
+
+// prepareStep substitutes URL keys and checks the step condition. Returns nil if the condition is not met.
+// A step which can not be prepared is returned with err set: it is not called and its OnError policy is applied.
+func (s *service) prepareStep(enh config.Enhance, tmp []byte, vals map[string][]byte) *enhanceStep {
+	step := &enhanceStep{
+		enh:        enh,
+		source:     tmp,
+		cbName:     config.BreakerName(enh.URL),
+		metricName: serviceName(enh.URL),
+		start:      time.Now(), // metric
+	}
+
+	// List of keys in current URL: [["{$key}", "$key"], ...]
+	// (per-element ForEach calls resolve the keys against the element)
+	var keys [][]string
+	if enh.ForEach == "" || enh.InArray {
+		keys = regexpMap["urlKey"].FindAllStringSubmatch(enh.URL, -1)
+	}
+
+	// Replace all keys in URL with the corresponding values from the body
+	for i := 0; i < len(keys); i++ {
+		key := keys[i][1] // "$key"
+		if _, ok := vals[key]; !ok {
+			val, err := jsonslice.Get(tmp, key) // Get value from the body by key
+			if err == nil && len(val) == 0 {
+				err = errors.New("not found")
 			}
-			var value interface{}
-			err = json.Unmarshal(v, &value)
 			if err != nil {
-				s.log.L().Errorf("json.Unmarshal(\"%s\") : %s", string(v), err.Error())
-				continue
+				step.err = fmt.Errorf("URL key %s: %w", key, err)
+				return step
 			}
-			// embed value
-			vobj := obj.(map[string]interface{})
-			vobj[dst.To] = value
+			if val[0] == '"' { // If val is in double quotes (json string) then get rid of quotes
+				val = val[1 : len(val)-1]
+			}
+			vals[key] = val
+
 		}
+		enh.URL = strings.Replace(enh.URL, keys[i][0], string(vals[key]), -1)
+	}
 
-		// write metrics for external service call
-		s.metrics.Score(s.method, s.vpath, serviceMetricName, startTime, nil)
+	// do not execute preprocessing step if condition is not met
+	if enh.Condition != "" {
+		cond := "$[?(" + enh.Condition + ")]"
+		result, err := jsonslice.Get([]byte("["+string(tmp)+"]"), cond)
+		if err != nil {
+			step.err = fmt.Errorf("condition %s: %w", enh.Condition, err)
+			return step
+		}
+		if string(result) == "[]" {
+			return nil
+		}
 	}
 
-	body, _ = json.Marshal(obj)
+	step.enh = enh
+	step.metricName = serviceName(enh.URL)
+	return step
+}
 
-	if err != nil {
-		s.log.L().Error(err)
-		return body
+// serviceName generates service name for metrics from external service URL
+func serviceName(url string) string {
+	submatches := regexpMap["extServiceName"].FindAllStringSubmatch(url, -1)
+	if submatches == nil {
+		return "external"
 	}
-
-	return body
+	// http://domain.com/api/v1/some/service?param=foo -> api/v1/some/service
+	name := submatches[0][1]
+	// Split extServiceName into substrings of [:word:] class symbols (a-zA-Z0-9_)
+	// api/v1/some/service -> ["api","v1","some","service"]
+	substrings := regexpMap["splitExtServiceName"].FindAllString(name, -1)
+	// Finally concatenate substrings with "_" separator into extServiceName
+	// ["api","v1","some","service"] -> api_v1_some_service
+	return strings.Join(substrings, "_")
 }
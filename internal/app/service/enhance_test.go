@@ -0,0 +1,509 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowHandler replies {"v":1} after the delay (or when the client gives up)
+func slowHandler(delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"v":1}`))
+	}
+}
+
+func Test_EnhanceTimeout(t *testing.T) {
+	srv := httptest.NewServer(slowHandler(300 * time.Millisecond))
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	enhance := []config.Enhance{{
+		URL:            srv.URL + "/slow",
+		Timeout:        50,
+		TransferFields: []config.TransferFields{{From: "$.v", To: "v"}},
+		OnError:        config.OnErrorFail,
+	}}
+	_, _, err := s.enhanceData([]byte(`{"id":1}`), enhance, nil, time.Second, config.MethodConfig{})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "timeout", err.reason)
+		assert.Equal(t, http.StatusBadGateway, err.status)
+	}
+}
+
+func Test_EnhanceBudget(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/first", slowHandler(150*time.Millisecond))
+	mux.Handle("/late", slowHandler(2*time.Second))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	// the second step reads the field written by the first one, so it starts when the first one is done
+	enhance := []config.Enhance{{
+		URL:            srv.URL + "/first",
+		TransferFields: []config.TransferFields{{From: "$.v", To: "a"}},
+	}, {
+		URL:            srv.URL + "/late?a={$.a}",
+		Timeout:        5000,
+		TransferFields: []config.TransferFields{{From: "$.v", To: "b"}},
+		OnError:        config.OnErrorFail,
+	}}
+	_, enriched, err := s.enhanceData([]byte(`{"id":1}`), enhance, nil, time.Second, config.MethodConfig{EnhanceBudget: 300})
+	assert.Equal(t, []string{"a"}, enriched)
+	// the late step got the rest of the budget, not its own Timeout (longer than the reply delay)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "timeout", err.reason)
+	}
+
+	// no time left: the step is skipped
+	enhance = []config.Enhance{{
+		URL:            srv.URL + "/late",
+		Timeout:        400,
+		TransferFields: []config.TransferFields{{From: "$.v", To: "a"}},
+	}, {
+		URL:            srv.URL + "/first",
+		TransferFields: []config.TransferFields{{From: "$.v", To: "a"}},
+		OnError:        config.OnErrorFail,
+	}}
+	_, _, err = s.enhanceData([]byte(`{"id":1}`), enhance, nil, time.Second, config.MethodConfig{EnhanceBudget: 300})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "budget", err.reason)
+	}
+}
+
+func Test_EnhanceParallel(t *testing.T) {
+	// /pair holds a call until the other one comes in (or the wait is over) and counts calls in flight
+	var mx sync.Mutex
+	var inflight, peak int
+	var arrived chan struct{}
+	var wait time.Duration
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pair", func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		if inflight == 2 {
+			close(arrived)
+		}
+		ch, d := arrived, wait
+		mx.Unlock()
+		defer func() {
+			mx.Lock()
+			inflight--
+			mx.Unlock()
+		}()
+		select {
+		case <-ch:
+		case <-time.After(d):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"v":1}`))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"v":"` + r.URL.Query().Get("a") + `"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	// a and b are independent, c reads a
+	enhance := []config.Enhance{{
+		URL:            srv.URL + "/pair?x=a",
+		TransferFields: []config.TransferFields{{From: "$.v", To: "a"}},
+	}, {
+		URL:            srv.URL + "/pair?x=b",
+		TransferFields: []config.TransferFields{{From: "$.v", To: "b"}},
+	}, {
+		URL:            srv.URL + "/echo?a={$.a}",
+		TransferFields: []config.TransferFields{{From: "$.v", To: "c"}},
+	}}
+	mx.Lock()
+	arrived, wait = make(chan struct{}), 5*time.Second
+	mx.Unlock()
+	body, enriched, err := s.enhanceData([]byte(`{"id":1}`), enhance, nil, 10*time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, enriched)
+	assert.JSONEq(t, `{"id":1,"a":1,"b":1,"c":"1"}`, string(body))
+	mx.Lock()
+	assert.Equal(t, 2, peak) // a and b were called together
+
+	// a single thread runs the steps one by one
+	peak = 0
+	arrived, wait = make(chan struct{}), 10*time.Millisecond
+	mx.Unlock()
+	body, _, err = s.enhanceData([]byte(`{"id":1}`), enhance, nil, 10*time.Second, config.MethodConfig{EnhanceThreads: 1})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"id":1,"a":1,"b":1,"c":"1"}`, string(body))
+	mx.Lock()
+	assert.Equal(t, 1, peak)
+	mx.Unlock()
+}
+
+func Test_EnhanceCache(t *testing.T) {
+	var calls, revalidated int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fresh", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"v":"fresh"}`))
+	})
+	mux.HandleFunc("/stale", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"1"`)
+		if r.Header.Get("If-None-Match") == `"1"` {
+			atomic.AddInt32(&revalidated, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"v":"stale"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	for _, tst := range []struct {
+		path        string
+		calls       int32
+		revalidated int32
+	}{
+		{"/fresh", 1, 0}, // the second call is served from the cache
+		{"/stale", 2, 1}, // the second call revalidates the cached response
+	} {
+		atomic.StoreInt32(&calls, 0)
+		atomic.StoreInt32(&revalidated, 0)
+		enhance := []config.Enhance{{
+			URL:            srv.URL + tst.path + "?id={$.id}",
+			Cache:          config.EnhanceCache{TTL: 60},
+			TransferFields: []config.TransferFields{{From: "$.v", To: "v"}},
+		}}
+		for i := 0; i < 2; i++ {
+			body, _, err := s.enhanceData([]byte(`{"id":1}`), enhance, nil, time.Second, config.MethodConfig{})
+			assert.Nil(t, err)
+			assert.JSONEq(t, `{"id":1,"v":"`+tst.path[1:]+`"}`, string(body), tst.path)
+		}
+		assert.Equal(t, tst.calls, atomic.LoadInt32(&calls), tst.path)
+		assert.Equal(t, tst.revalidated, atomic.LoadInt32(&revalidated), tst.path)
+	}
+
+	// every step gets a cache of its own size
+	small := s.cache("GET "+srv.URL, config.EnhanceCache{TTL: 60, Size: 10})
+	assert.Same(t, small, s.cache("GET "+srv.URL, config.EnhanceCache{TTL: 30, Size: 10}))
+	assert.NotSame(t, small, s.cache("GET "+srv.URL, config.EnhanceCache{TTL: 60, Size: 20}))
+}
+
+func Test_EnhanceBreaker(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		code, _ := strconv.Atoi(r.URL.Path[1:])
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	for _, tst := range []struct {
+		status int
+		calls  int32 // of 3 with Failures 2
+	}{
+		{http.StatusBadRequest, 3},      // the service is up
+		{http.StatusNotFound, 3},        // the service is up
+		{http.StatusTooManyRequests, 2}, // overloaded
+		{http.StatusBadGateway, 2},      // down
+	} {
+		s := newTestService(&config.Config{})
+		atomic.StoreInt32(&calls, 0)
+		enhance := []config.Enhance{{
+			URL:            srv.URL + "/" + strconv.Itoa(tst.status),
+			Breaker:        config.Breaker{Failures: 2},
+			TransferFields: []config.TransferFields{{From: "$.v", To: "v"}},
+		}}
+		for i := 0; i < 3; i++ {
+			s.enhanceData([]byte(`{"id":1}`), enhance, nil, time.Second, config.MethodConfig{})
+		}
+		assert.Equal(t, tst.calls, atomic.LoadInt32(&calls), tst.status)
+	}
+}
+
+func Test_EnhanceStage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"v":"` + r.URL.Path[1:] + `"}`))
+	}))
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	enhance := []config.Enhance{
+		{URL: srv.URL + "/req", TransferFields: []config.TransferFields{{From: "$.v", To: "req"}}},
+		{URL: srv.URL + "/resp", Stage: config.StageResponse, TransferFields: []config.TransferFields{{From: "$.v", To: "$[*].resp"}}},
+		{URL: srv.URL + "/req2", Stage: config.StageRequest, TransferFields: []config.TransferFields{{From: "$.v", To: "req2"}}},
+	}
+	request := stageSteps(enhance, config.StageRequest)
+	response := stageSteps(enhance, config.StageResponse)
+	assert.Equal(t, []config.Enhance{enhance[0], enhance[2]}, request)
+	assert.Equal(t, []config.Enhance{enhance[1]}, response)
+
+	body, enriched, err := s.enhanceData([]byte(`{"id":1}`), request, nil, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"req", "req2"}, enriched)
+	assert.JSONEq(t, `{"id":1,"req":"req","req2":"req2"}`, string(body))
+
+	// a function result may be an array
+	body, enriched, err = s.enhanceData([]byte(`[{"id":1},{"id":2}]`), response, nil, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"$[*].resp"}, enriched)
+	assert.JSONEq(t, `[{"id":1,"resp":"resp"},{"id":2,"resp":"resp"}]`, string(body))
+
+	// scalar results are not enriched
+	body, enriched, err = s.enhanceData([]byte(`42`), response, nil, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.Nil(t, enriched)
+	assert.Equal(t, `42`, string(body))
+}
+
+func Test_EnhanceTransfer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"prices":[10,20],"brand":"X","tags":["new"]}`))
+	}))
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	for _, tst := range []struct {
+		transfer []config.TransferFields
+		body     string
+		result   string
+		enriched []string
+	}{
+		{ // pairwise
+			[]config.TransferFields{{From: "$.prices[*]", To: "$.items[*].price"}},
+			`{"items":[{"id":1},{"id":2}]}`,
+			`{"items":[{"id":1,"price":10},{"id":2,"price":20}]}`,
+			[]string{"$.items[*].price"},
+		},
+		{ // whole value to every element
+			[]config.TransferFields{{From: "$.prices", To: "$.items[*].prices"}, {From: "$.brand", To: "$.items[*].brand"}},
+			`{"items":[{"id":1},{"id":2}]}`,
+			`{"items":[{"id":1,"prices":[10,20],"brand":"X"},{"id":2,"prices":[10,20],"brand":"X"}]}`,
+			[]string{"$.items[*].prices", "$.items[*].brand"},
+		},
+		{ // the number of elements differs: nothing is set
+			[]config.TransferFields{{From: "$.prices[*]", To: "$.items[*].price"}},
+			`{"items":[{"id":1}]}`,
+			`{"items":[{"id":1}]}`,
+			nil,
+		},
+		{ // missing target is not created
+			[]config.TransferFields{{From: "$.brand", To: "$.a[0]"}},
+			`{"x":1}`,
+			`{"x":1}`,
+			nil,
+		},
+		{ // modes and defaults
+			[]config.TransferFields{
+				{From: "$.tags", To: "tags", Mode: "append"},
+				{From: "$.brand", To: "$.product", Mode: "merge"},
+				{From: "$.missing", To: "color", Default: "none"},
+			},
+			`{"tags":["old"],"product":{"id":1}}`,
+			`{"tags":["old","new"],"product":"X","color":"none"}`,
+			[]string{"tags", "$.product", "color"},
+		},
+	} {
+		enhance := []config.Enhance{{URL: srv.URL + "/", TransferFields: tst.transfer}}
+		body, enriched, err := s.enhanceData([]byte(tst.body), enhance, nil, time.Second, config.MethodConfig{})
+		assert.Nil(t, err)
+		assert.JSONEq(t, tst.result, string(body), tst.body)
+		assert.Equal(t, tst.enriched, enriched, tst.body)
+	}
+}
+
+func Test_EnhanceForEach(t *testing.T) {
+	var batches [][]map[string]int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/price", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if id == "3" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"price":` + id + `0}`))
+	})
+	mux.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		var batch []map[string]int
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		batches = append(batches, batch)
+		var res []map[string]int
+		for _, item := range batch {
+			res = append(res, map[string]int{"price": item["id"] * 10})
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	mux.HandleFunc("/sku/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"path": r.URL.EscapedPath(), "q": r.URL.Query().Get("q")})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	// a call per element
+	enhance := []config.Enhance{{
+		URL:            srv.URL + "/price",
+		ForEach:        "$.items",
+		IncomingFields: []string{"$.id"},
+		ForwardFields:  []string{"id"},
+		TransferFields: []config.TransferFields{{From: "$.price", To: "price"}},
+	}}
+	body, enriched, err := s.enhanceData([]byte(`{"items":[{"id":1},{"id":2},{"id":3}]}`), enhance, nil, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"$.items[*].price"}, enriched)
+	assert.JSONEq(t, `{"items":[{"id":1,"price":10},{"id":2,"price":20},{"id":3}]}`, string(body))
+
+	// the step fails on element error
+	enhance[0].ElementErrors = config.ElementFail
+	enhance[0].OnError = config.OnErrorFail
+	_, _, err = s.enhanceData([]byte(`{"items":[{"id":1},{"id":3}]}`), enhance, nil, time.Second, config.MethodConfig{})
+	assert.NotNil(t, err)
+
+	// element values are escaped in the URL
+	enhance = []config.Enhance{{
+		URL:            srv.URL + "/sku/{$.sku}?q={$.q}",
+		ForEach:        "$.items",
+		TransferFields: []config.TransferFields{{From: "$.path", To: "path"}, {From: "$.q", To: "q"}},
+	}}
+	body, _, err = s.enhanceData([]byte(`{"items":[{"sku":"a/b?c d","q":"x&y=z#"}]}`), enhance, nil, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"items":[{"sku":"a/b?c d","q":"x&y=z#","path":"/sku/a%2Fb%3Fc%20d"}]}`, string(body))
+
+	// a single batched call, n-th reply item goes to n-th element
+	enhance = []config.Enhance{{
+		URL:            srv.URL + "/batch",
+		Method:         http.MethodPost,
+		ForEach:        "$.order.items",
+		InArray:        true,
+		IncomingFields: []string{"$.id"},
+		ForwardFields:  []string{"id"},
+		TransferFields: []config.TransferFields{{From: "$.price", To: "$.price"}},
+	}}
+	body, enriched, err = s.enhanceData([]byte(`{"order":{"items":[{"id":2},{"id":1}]}}`), enhance, nil, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"$.order.items[*].price"}, enriched)
+	assert.JSONEq(t, `{"order":{"items":[{"id":2,"price":20},{"id":1,"price":10}]}}`, string(body))
+	assert.Equal(t, [][]map[string]int{{{"id": 2}, {"id": 1}}}, batches)
+}
+
+func Test_EnhanceRequest(t *testing.T) {
+	var method, auth, trace, contentType, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		method, auth, trace, contentType, body = r.Method, r.Header.Get("Authorization"), r.Header.Get("X-Trace-Id"), r.Header.Get("Content-Type"), string(buf)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	enhance := []config.Enhance{{
+		URL:          srv.URL + "/orders/{$.id}",
+		Method:       http.MethodPut,
+		BodyTemplate: map[string]interface{}{"order": map[string]interface{}{"id": "{$.id}", "note": "id {$.id}"}},
+		HeadersToSend: []config.HeaderToSend{
+			{Header: "Authorization", From: "Authorization"},
+			{Header: "X-Trace-Id", From: "X-Trace-Id", Value: "t-{$.id}"},
+		},
+		TransferFields: []config.TransferFields{{From: "$.ok", To: "ok"}},
+	}}
+	in := incomingHeaders(config.MethodConfig{Enhance: enhance}, http.Header{"Authorization": {"Bearer x"}, "Cookie": {"a=1"}})
+	assert.Equal(t, http.Header{"Authorization": {"Bearer x"}}, in)
+
+	res, _, err := s.enhanceData([]byte(`{"id":7}`), enhance, in, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"id":7,"ok":true}`, string(res))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "Bearer x", auth)
+	assert.Equal(t, "t-7", trace) // incoming header is missing, Value is used
+	assert.Equal(t, "application/json", contentType)
+	assert.JSONEq(t, `{"order":{"id":7,"note":"id 7"}}`, body)
+
+	// form encoding
+	enhance[0].Method = http.MethodPost
+	enhance[0].Encoding = config.EncodingForm
+	enhance[0].BodyTemplate = map[string]interface{}{"id": "{$.id}", "name": "{$.name}"}
+	_, _, err = s.enhanceData([]byte(`{"id":7,"name":"a b"}`), enhance, nil, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, "application/x-www-form-urlencoded", contentType)
+	assert.Equal(t, "id=7&name=a+b", body)
+	assert.Equal(t, "", auth)
+}
+
+func Test_EnhanceOnError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"v":1}`))
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	first := config.Enhance{URL: srv.URL + "/ok", TransferFields: []config.TransferFields{{From: "$.v", To: "a"}}}
+	for _, tst := range []struct {
+		name     string
+		step     config.Enhance
+		status   int // 0 = no error
+		result   string
+		enriched []string
+	}{
+		{"ignore", config.Enhance{URL: srv.URL + "/down?a={$.a}"}, 0, `{"id":1,"a":1}`, []string{"a"}},
+		{"fail", config.Enhance{URL: srv.URL + "/down?a={$.a}", OnError: config.OnErrorFail, ErrorStatus: 503}, 503, ``, []string{"a"}},
+		{"fallback", config.Enhance{URL: srv.URL + "/down?a={$.a}", OnError: config.OnErrorFallback, TransferFields: []config.TransferFields{{From: "$.v", To: "b", Default: 0}}}, 0, `{"id":1,"a":1,"b":0}`, []string{"a"}},
+		{"condition not met", config.Enhance{URL: srv.URL + "/down?a={$.a}", Condition: "@.id > 5", OnError: config.OnErrorFail}, 0, `{"id":1,"a":1}`, []string{"a"}},
+		{"unresolved URL key", config.Enhance{URL: srv.URL + "/ok?x={$.missing}", OnError: config.OnErrorFail}, 502, ``, []string{"a"}},
+		{"invalid condition", config.Enhance{URL: srv.URL + "/ok?a={$.a}", Condition: "@.id >", OnError: config.OnErrorFail}, 502, ``, []string{"a"}},
+	} {
+		body, enriched, err := s.enhanceData([]byte(`{"id":1}`), []config.Enhance{first, tst.step}, nil, time.Second, config.MethodConfig{})
+		assert.Equal(t, tst.enriched, enriched, tst.name)
+		if tst.status == 0 {
+			assert.Nil(t, err, tst.name)
+			assert.JSONEq(t, tst.result, string(body), tst.name)
+			continue
+		}
+		if !assert.NotNil(t, err, tst.name) {
+			continue
+		}
+		// response to the client
+		w := httptest.NewRecorder()
+		setEnriched(w, enriched)
+		s.writeError(w, err.status, err.apiError())
+		assert.Equal(t, tst.status, w.Code, tst.name)
+		assert.Equal(t, "a", w.Header().Get(enrichedHeader), tst.name)
+		assert.JSONEq(t, `{"error":"enrichment failed","details":{"service":"`+err.service+`","reason":"error"}}`, w.Body.String(), tst.name)
+	}
+
+	// failed elements get Default values with fallback policy
+	enhance := []config.Enhance{{
+		URL:            srv.URL + "/{$.path}",
+		ForEach:        "$.items",
+		OnError:        config.OnErrorFallback,
+		TransferFields: []config.TransferFields{{From: "$.v", To: "v", Default: -1}},
+	}}
+	body, enriched, err := s.enhanceData([]byte(`{"items":[{"path":"ok"},{"path":"down"}]}`), enhance, nil, time.Second, config.MethodConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"$.items[*].v"}, enriched)
+	assert.JSONEq(t, `{"items":[{"path":"ok","v":1},{"path":"down","v":-1}]}`, string(body))
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// apiError is an error returned to the client as a JSON object
+type apiError struct {
+	Message string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// writeError writes error response: JSON for apiError, plain text otherwise
+func (s *service) writeError(w http.ResponseWriter, code int, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	buf, _ := json.Marshal(e)
+	if s.cfg.HTTP.CORS {
+		s.allowCORS(w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.WriteHeader(code)
+	w.Write(buf)
+}
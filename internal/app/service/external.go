@@ -8,20 +8,34 @@ import (
 	"io/ioutil"
 	"math"
 	"net/http"
+	"net/url"
 	"reflect"
 	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/bhmj/jsonslice"
+	"github.com/bhmj/pg-api/internal/pkg/bodytpl"
 	"github.com/bhmj/pg-api/internal/pkg/config"
 )
 
-func (s *service) queryExternal(enh config.Enhance, sourceJSON []byte, timeout time.Duration) (response []byte, flds map[string]interface{}, err error) {
+// externalCall is a prepared external service request
+type externalCall struct {
+	method      string
+	url         string
+	header      http.Header
+	body        []byte
+	contentType string
+	payload     interface{}            // body value before encoding
+	flds        map[string]interface{} // forwarded fields
+}
+
+// externalRequest prepares external service request from the source JSON.
+// in contains incoming headers HeadersToSend values may be taken from.
+func (s *service) externalRequest(enh config.Enhance, sourceJSON []byte, in http.Header) (call *externalCall, err error) {
 
-	var req *http.Request
 	var body []byte
-	flds = make(map[string]interface{})
+	flds := make(map[string]interface{})
 
 	arrayMode := false
 	if len(enh.ForwardFields) > 0 && enh.ForwardFields[0] == "[]" {
@@ -78,34 +92,107 @@ func (s *service) queryExternal(enh config.Enhance, sourceJSON []byte, timeout t
 		return
 	}
 
-	if enh.Method == "POST" {
-		if arrayMode {
-			body, err = json.Marshal([]interface{}{flds[enh.ForwardFields[0]]})
-		} else if enh.InArray {
-			body, err = json.Marshal([]interface{}{flds})
+	call = &externalCall{method: enh.Method, url: enh.URL, flds: flds}
+	if call.header, err = callHeaders(enh, sourceJSON, in); err != nil {
+		return nil, err
+	}
+	switch {
+	case enh.BodyTemplate != nil:
+		if call.payload, err = bodytpl.Render(enh.BodyTemplate, sourceJSON); err != nil {
+			return nil, err
+		}
+	case arrayMode:
+		call.payload = flds[enh.ForwardFields[0]]
+	default:
+		call.payload = flds
+	}
+	if hasBody[enh.Method] {
+		if enh.Encoding == config.EncodingForm {
+			obj, ok := call.payload.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("form body should be an object")
+			}
+			form := make(url.Values)
+			for key, value := range obj {
+				form.Set(key, bodytpl.Text(value))
+			}
+			call.body = []byte(form.Encode())
+			call.contentType = "application/x-www-form-urlencoded"
+			return call, nil
+		}
+		if enh.BodyTemplate == nil && (arrayMode || enh.InArray) {
+			body, err = json.Marshal([]interface{}{call.payload})
 		} else {
-			body, err = json.Marshal(flds)
+			body, err = json.Marshal(call.payload)
 		}
 		if err != nil {
-			return
+			return nil, err
 		}
-		req, err = http.NewRequest(enh.Method, enh.URL, bytes.NewReader(body))
-		req.Header.Add("Content-Type", "application/json")
-		req.Header.Add("Content-Length", strconv.Itoa(len(body)))
+		call.body = body
+		call.contentType = "application/json"
 	} else {
-		req, err = http.NewRequest(enh.Method, enh.URL, nil)
+		u, err := url.Parse(enh.URL)
 		if err != nil {
-			return
+			return nil, err
 		}
-		q := req.URL.Query()
+		q := u.Query()
 		for key, value := range flds {
 			q.Add(key, fmt.Sprintf("%v", value))
 		}
-		req.URL.RawQuery = q.Encode()
+		u.RawQuery = q.Encode()
+		call.url = u.String()
 	}
+	return call, nil
+}
 
-	for i := 0; i < len(enh.HeadersToSend); i++ {
-		req.Header.Add(enh.HeadersToSend[i].Header, enh.HeadersToSend[i].Value)
+// callHeaders returns HeadersToSend values: from incoming headers or from Value with body placeholders
+func callHeaders(enh config.Enhance, sourceJSON []byte, in http.Header) (http.Header, error) {
+	if len(enh.HeadersToSend) == 0 {
+		return nil, nil
+	}
+	header := make(http.Header)
+	for _, h := range enh.HeadersToSend {
+		var value string
+		if h.From != "" {
+			value = in.Get(h.From)
+		}
+		if value == "" {
+			var err error
+			if value, err = bodytpl.Expand(h.Value, sourceJSON); err != nil {
+				return nil, err
+			}
+		}
+		if value != "" {
+			header.Add(h.Header, value)
+		}
+	}
+	return header, nil
+}
+
+// queryExternal makes external service request. etag is sent in If-None-Match header if not empty.
+func (s *service) queryExternal(enh config.Enhance, call *externalCall, timeout time.Duration, etag string) (response []byte, header http.Header, err error) {
+	var req *http.Request
+	if call.body != nil {
+		req, err = http.NewRequest(call.method, call.url, bytes.NewReader(call.body))
+		if err != nil {
+			return
+		}
+		req.Header.Add("Content-Type", call.contentType)
+		req.Header.Add("Content-Length", strconv.Itoa(len(call.body)))
+	} else {
+		req, err = http.NewRequest(call.method, call.url, nil)
+		if err != nil {
+			return
+		}
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	for name, values := range call.header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
 	}
 	req.Header.Set("Connection", "close")
 
@@ -119,11 +206,11 @@ func (s *service) queryExternal(enh config.Enhance, sourceJSON []byte, timeout t
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("%s: status %d", enh.URL, resp.StatusCode)
-		return
+		err = &statusError{url: enh.URL, code: resp.StatusCode}
+		return nil, resp.Header, err
 	}
 
-	body, err = ioutil.ReadAll(resp.Body)
+	response, err = ioutil.ReadAll(resp.Body)
 
-	return body, flds, nil
+	return response, resp.Header, err
 }
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	phttp "github.com/bhmj/pg-api/internal/pkg/http"
+	"github.com/bhmj/pg-api/internal/pkg/jobs"
+)
+
+const jobFinalize = "finalize"
+
+// finalizeJob contains everything needed to run finalize scenario outside of the request
+type finalizeJob struct {
+	Method   string              `json:"method"`
+	Path     string              `json:"path"` // method path without version
+	Version  int                 `json:"version"`
+	UserID   int64               `json:"user_id,omitempty"`
+	Tenant   string              `json:"tenant,omitempty"`
+	Shard    string              `json:"shard,omitempty"`
+	Body     string              `json:"body,omitempty"`
+	Headers  []phttp.HeaderValue `json:"headers,omitempty"`
+	ID       int64               `json:"id"`                 // main function result ID
+	Callback string              `json:"callback,omitempty"` // client callback URL or webhook
+	Token    string              `json:"token"`              // job status access token
+}
+
+// startFinalize runs finalize scenario in the background: in the job queue if enabled, in memory otherwise.
+// Returns queued job ID and its status access token, or 0.
+func (s *service) startFinalize(parsed ParsedURL, grp *dbGroup, body []byte, headers []phttp.HeaderValue, in http.Header, id int64, callback string) (int64, string) {
+	if s.queue != nil {
+		token, err := newJobToken()
+		job := finalizeJob{
+			Method:   parsed.Method,
+			Path:     parsed.Path,
+			Version:  parsed.Version,
+			UserID:   parsed.UserID,
+			Tenant:   grp.tenant,
+			Shard:    grp.shard,
+			Body:     string(body),
+			Headers:  headers,
+			ID:       id,
+			Callback: callback,
+			Token:    token,
+		}
+		var jobID int64
+		if err == nil {
+			jobID, err = s.queue.Enqueue(s.ctx, jobFinalize, job)
+		}
+		if err == nil {
+			return jobID, token
+		}
+		s.log.L().Errorf("finalize %s id=%d: job queue: %s, running in memory", parsed.MethodPath, id, err.Error())
+	}
+	s.acquireGroup(grp)
+	started := s.background(fmt.Sprintf("finalize %s id=%d", parsed.MethodPath, id), func() {
+		defer s.releaseGroup(grp)
+		_, _ = s.finalize(parsed, grp, body, headers, in, id)
+	})
+	if !started {
+		s.releaseGroup(grp)
+	}
+	return 0, ""
+}
+
+// runFinalizeJob is the job queue handler for finalize scenario
+func (s *service) runFinalizeJob(ctx context.Context, j jobs.Job) (string, error) {
+	var job finalizeJob
+	if err := json.Unmarshal(j.Payload, &job); err != nil {
+		return "", err
+	}
+	parsed, err := s.parseURL(job.Method, job.Path, job.Version, s.cfg)
+	if err != nil {
+		return "", err
+	}
+	parsed.UserID = job.UserID
+	grp, err := s.jobGroup(job.Tenant, job.Shard)
+	if err != nil {
+		return "", err
+	}
+	defer s.releaseGroup(grp)
+	return s.finalize(parsed, grp, []byte(job.Body), job.Headers, nil, job.ID) // incoming headers are not stored with jobs
+}
+
+// finalize does the pre-processing, calls finalizing function and does the post-processing
+func (s *service) finalize(parsed ParsedURL, grp *dbGroup, rawBody []byte, headers []phttp.HeaderValue, in http.Header, id int64) (string, error) {
+	body := rawBody
+	var result string
+
+	if enhance := stageSteps(parsed.Enhance, config.StageRequest); len(enhance) > 0 {
+		// pre-processing
+		var eerr *enhanceError
+		if body, _, eerr = s.enhanceData(rawBody, enhance, in, defaultBackgroundTimeout, parsed.MethodConfig); eerr != nil {
+			s.log.L().Errorf("finalize %s id=%d: %s", parsed.MethodPath, id, eerr.Error())
+			return "", eerr // the job is retried
+		}
+	}
+
+	// finalizing query
+	query, args := s.prepareSQL(grp.writeSource, grp.writeSchema, parsed, string(body), headers, id)
+	err := s.makeDBRequest(grp.dbw, query, args, &result)
+	if err != nil {
+		s.log.L().Errorf("finalizing query: %s, error: %s", query, err.Error())
+		return "", err
+	}
+	s.log.L().Infof("finalizing query result: %s", result)
+
+	// post-processing
+	s.startPostproc(parsed, grp, []byte(result), in, id)
+	return result, nil
+}
+
+// jobGroup returns database connections for a job by tenant and shard name.
+// The group must be released with releaseGroup when the job is done.
+func (s *service) jobGroup(tenant string, shard string) (*dbGroup, error) {
+	grp := s.db
+	if tenant != "" {
+		grp = nil
+		for i := range s.cfg.Tenants.List {
+			if s.cfg.Tenants.List[i].Name == tenant {
+				var err error
+				if grp, err = s.openTenant(&s.cfg.Tenants.List[i]); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		if grp == nil {
+			return nil, errUnknownTenant
+		}
+	}
+	if shard != "" {
+		s.releaseGroup(grp) // sharded calls never use tenant's own connections
+		for _, sh := range s.shards {
+			if sh.shard == shard {
+				return tenantShard(sh, grp), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown shard %s", shard)
+	}
+	return grp, nil
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FinalizeJob(t *testing.T) {
+	dbw, _ := sql.Open("pgapi_exec", "")
+	defer dbw.Close()
+	cfg := &config.Config{}
+	cfg.General.Convention = "CRUD"
+	cfg.Methods = []config.MethodConfig{{
+		Name:         []string{"/orders/"},
+		NameMatch:    []*regexp.Regexp{regexp.MustCompile("/orders/")},
+		VersionFrom:  1,
+		FinalizeName: []string{"orders_finalize"},
+	}}
+	s := newTestService(cfg)
+	s.db = &dbGroup{dbw: dbw, writeSchema: "api"}
+
+	// the job without pre-processing steps passes the stored body to the finalizing function
+	payload, _ := json.Marshal(finalizeJob{Method: "POST", Path: "orders/", Version: 1, Body: `{"sku":"a1"}`, ID: 5})
+	testDriver.reset(0)
+	result, err := s.runFinalizeJob(s.ctx, jobs.Job{ID: 1, Kind: jobFinalize, Payload: payload})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":1}`, result)
+	assert.Equal(t, [][]driver.Value{{int64(5), `{"sku":"a1"}`}}, testDriver.calls)
+}
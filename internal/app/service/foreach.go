@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/bodytpl"
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/jpath"
+)
+
+// elementResult is a ForEach step call result for one array element
+type elementResult struct {
+	data []byte
+	flds map[string]interface{}
+	err  error
+}
+
+// callForEach calls external service for every element of ForEach array:
+// one call per element in parallel, or a single batched call if InArray is set
+func (s *service) callForEach(step *enhanceStep, timeout time.Duration, deadline time.Time, threads int) ([]elementResult, error) {
+	var doc interface{}
+	if err := json.Unmarshal(step.source, &doc); err != nil {
+		return nil, err
+	}
+	v, err := jpath.Get(doc, step.enh.ForEach)
+	if err != nil {
+		return nil, err
+	}
+	elements, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is not an array", step.enh.ForEach)
+	}
+	if len(elements) == 0 {
+		return nil, nil
+	}
+	if step.enh.InArray {
+		return s.callBatch(step, elements, timeout, deadline)
+	}
+
+	results := make([]elementResult, len(elements))
+	sem := make(chan struct{}, threads)
+	var wg sync.WaitGroup
+	for i := range elements {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res := &results[i]
+			elem := *step
+			elem.source, _ = json.Marshal(elements[i])
+			if elem.enh.URL, res.err = expandURL(elem.enh.URL, elem.source); res.err != nil {
+				return
+			}
+			res.data, res.flds, res.err = s.callExternal(&elem, timeout, deadline)
+		}(i)
+	}
+	wg.Wait()
+	return results, s.elementErrors(step, results)
+}
+
+// expandURL replaces {$...} placeholders in the URL by escaped values of the source JSON
+func expandURL(tpl string, source []byte) (string, error) {
+	path, query := tpl, ""
+	if i := strings.IndexByte(tpl, '?'); i >= 0 {
+		path, query = tpl[:i], tpl[i:]
+	}
+	path, err := bodytpl.ExpandEscaped(path, source, url.PathEscape)
+	if err != nil {
+		return "", err
+	}
+	query, err = bodytpl.ExpandEscaped(query, source, url.QueryEscape)
+	return path + query, err
+}
+
+// callBatch sends fields of all elements in one request and maps response array back to the elements
+func (s *service) callBatch(step *enhanceStep, elements []interface{}, timeout time.Duration, deadline time.Time) ([]elementResult, error) {
+	enh := step.enh
+	enh.InArray = false // every element is forwarded as is
+	results := make([]elementResult, len(elements))
+	var batch []interface{}
+	var idx []int // batch index -> element index
+	for i := range elements {
+		source, _ := json.Marshal(elements[i])
+		call, err := s.externalRequest(enh, source, step.headers)
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		results[i].flds = call.flds
+		batch = append(batch, call.payload)
+		idx = append(idx, i)
+	}
+	if len(idx) > 0 {
+		body, err := json.Marshal(batch)
+		if err != nil {
+			return nil, err
+		}
+		header, err := callHeaders(enh, step.source, step.headers) // placeholders are taken from the body
+		if err != nil {
+			return nil, err
+		}
+		call := &externalCall{method: enh.Method, url: enh.URL, header: header, body: body, contentType: "application/json"}
+		data, _, err := s.doExternal(step, call, timeout, deadline)
+		if err != nil {
+			return nil, err
+		}
+		var items []json.RawMessage
+		if err = json.Unmarshal(data, &items); err != nil || len(items) != len(idx) {
+			return nil, fmt.Errorf("%s: batch response should be an array of %d elements", step.cbName, len(idx))
+		}
+		for n, i := range idx {
+			results[i].data = items[n]
+		}
+	}
+	return results, s.elementErrors(step, results)
+}
+
+// elementErrors returns the first element error if the step fails on element errors, otherwise logs them
+func (s *service) elementErrors(step *enhanceStep, results []elementResult) error {
+	for i, res := range results {
+		if res.err == nil {
+			continue
+		}
+		if step.enh.ElementErrors == config.ElementFail {
+			return fmt.Errorf("%s[%d]: %w", step.enh.ForEach, i, res.err)
+		}
+		s.log.L().Errorf("queryExternal: %s[%d]: %s", step.enh.ForEach, i, res.err.Error())
+		s.metrics.ExternalError(s.vpath, step.metricName, failureReason(res.err))
+	}
+	return nil
+}
+
+// embedElements embeds element responses into ForEach array elements,
+// failed elements get TransferFields Default values if the step OnError policy is fallback.
+// Returns paths of the embedded fields: $.items[*].price
+func (s *service) embedElements(obj interface{}, step *enhanceStep) []string {
+	v, err := jpath.Get(obj, step.enh.ForEach)
+	if err != nil {
+		s.log.L().Errorf("embed %s: %s", step.enh.ForEach, err.Error())
+		return nil
+	}
+	elements, ok := v.([]interface{})
+	if !ok || len(elements) != len(step.items) {
+		s.log.L().Errorf("embed %s: array has changed", step.enh.ForEach)
+		return nil
+	}
+	embedded := make(map[string]bool)
+	for i, res := range step.items {
+		if res.err != nil && step.enh.OnError == config.OnErrorFallback {
+			elements[i] = s.defaults(elements[i], step.enh) // failed element
+			continue
+		}
+		if res.err == nil && res.data != nil {
+			var fields []string
+			elements[i], fields = s.embed(elements[i], step.enh, res.data, res.flds)
+			for _, f := range fields {
+				embedded[f] = true
+			}
+		}
+	}
+	var paths []string
+	for _, dst := range step.enh.TransferFields { // in the order of definition
+		if embedded[dst.To] {
+			embedded[dst.To] = false
+			paths = append(paths, step.enh.ForEach+"[*]"+strings.TrimPrefix("."+dst.To, ".$"))
+		}
+	}
+	return paths
+}
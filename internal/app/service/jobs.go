@@ -0,0 +1,212 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/jobs"
+	"github.com/bhmj/pg-api/internal/pkg/str"
+)
+
+const (
+	jobCallback            = "callback"
+	defaultCallbackHeader  = "X-Callback-URL"
+	defaultCallbackTimeout = 10 // seconds
+	jobIDHeader            = "X-Job-ID"
+	jobTokenHeader         = "X-Job-Token"
+)
+
+// jobStatus is returned by job status endpoint
+type jobStatus struct {
+	ID        int64           `json:"id"`
+	State     string          `json:"state"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// callbackJob is a finalize result delivery to the client callback URL or webhook
+type callbackJob struct {
+	URL    string    `json:"url"`
+	Status jobStatus `json:"status"`
+}
+
+// newJobToken returns a random token which grants access to the job status
+func newJobToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// JobHandler returns finalize job status: GET /{endpoint}/jobs/{id} with the job token in X-Job-Token header
+func (s *service) JobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" && s.cfg.HTTP.CORS {
+		s.allowCORS(w)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.queue == nil {
+		http.Error(w, "job queue is not enabled", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/"+s.cfg.HTTP.Endpoint+"/jobs/"), "/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := s.getUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	st, err := s.queue.Status(r.Context(), id)
+	if err == nil && st.Kind != jobFinalize {
+		err = jobs.ErrNotFound
+	}
+	if err == nil {
+		// job IDs are sequential: the status is visible to the token holder only (and only to the owner if authenticated)
+		var job finalizeJob
+		token := r.Header.Get(jobTokenHeader)
+		if json.Unmarshal(st.Payload, &job) != nil || job.Token == "" ||
+			subtle.ConstantTimeCompare([]byte(job.Token), []byte(token)) != 1 ||
+			job.UserID != 0 && job.UserID != userID {
+			err = jobs.ErrNotFound
+		}
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == jobs.ErrNotFound {
+			code = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	buf, _ := json.Marshal(jobStatus{
+		ID:        st.ID,
+		State:     st.State,
+		Attempts:  st.Attempts,
+		LastError: st.LastError,
+		Result:    rawResult(st.Result),
+		CreatedAt: st.CreatedAt,
+		UpdatedAt: st.UpdatedAt,
+	})
+	if s.cfg.HTTP.CORS {
+		s.allowCORS(w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.Write(buf)
+}
+
+// callbackURL returns client callback URL from request header or method webhook
+func (s *service) callbackURL(r *http.Request, parsed ParsedURL) (string, error) {
+	callback := r.Header.Get(str.Scoalesce(s.cfg.Jobs.CallbackHeader, defaultCallbackHeader))
+	if callback == "" {
+		return parsed.Webhook, nil
+	}
+	if s.queue == nil {
+		return "", errors.New("callbacks require job queue")
+	}
+	u, err := url.Parse(callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("invalid callback URL")
+	}
+	for _, host := range s.cfg.Jobs.CallbackHosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return callback, nil
+		}
+	}
+	return "", fmt.Errorf("callback host %s is not allowed", u.Hostname())
+}
+
+// finishJob enqueues callback delivery when finalize job is done or dead
+func (s *service) finishJob(ctx context.Context, j jobs.Job, state string, result string, jobErr error) {
+	if j.Kind != jobFinalize {
+		return
+	}
+	var job finalizeJob
+	if err := json.Unmarshal(j.Payload, &job); err != nil || job.Callback == "" {
+		return
+	}
+	cb := callbackJob{
+		URL: job.Callback,
+		Status: jobStatus{
+			ID:        j.ID,
+			State:     state,
+			Attempts:  j.Attempts,
+			Result:    rawResult(result),
+			UpdatedAt: time.Now(),
+		},
+	}
+	if jobErr != nil {
+		cb.Status.LastError = jobErr.Error()
+	}
+	if _, err := s.queue.Enqueue(ctx, jobCallback, cb); err != nil {
+		s.log.L().Errorf("job %d: callback: %s", j.ID, err.Error())
+	}
+}
+
+// runCallbackJob posts finalize job status to the callback URL
+func (s *service) runCallbackJob(ctx context.Context, j jobs.Job) (string, error) {
+	var cb callbackJob
+	if err := json.Unmarshal(j.Payload, &cb); err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(cb.Status)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", cb.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(jobIDHeader, strconv.FormatInt(cb.Status.ID, 10))
+
+	client := &http.Client{
+		Timeout: time.Duration(str.Icoalesce(s.cfg.Jobs.CallbackTimeout, defaultCallbackTimeout)) * time.Second,
+		// redirects are not followed: the target host would bypass CallbackHosts
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%s: status %d", cb.URL, resp.StatusCode)
+	}
+	return strconv.Itoa(resp.StatusCode), nil
+}
+
+// rawResult returns function result as JSON value
+func rawResult(result string) json.RawMessage {
+	if result == "" {
+		return nil
+	}
+	if json.Valid([]byte(result)) {
+		return json.RawMessage(result)
+	}
+	buf, _ := json.Marshal(result)
+	return buf
+}
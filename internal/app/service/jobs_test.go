@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/db"
+	"github.com/bhmj/pg-api/internal/pkg/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_JobHandler(t *testing.T) {
+	users := map[string]int64{"alice": 1, "bob": 2}
+	queued := map[int64]struct {
+		kind string
+		job  finalizeJob
+	}{
+		1: {jobFinalize, finalizeJob{Token: "t1", UserID: 1}},
+		2: {jobCallback, finalizeJob{Token: "t2"}},
+		3: {jobFinalize, finalizeJob{Token: "t3"}}, // anonymous
+	}
+	testRowsDriver.reply(func(dsn string, query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "auth_check") {
+			return []string{"user_id", "code"}, [][]driver.Value{{users[args[0].(string)], int64(200)}}
+		}
+		columns := []string{"id", "kind", "payload", "state", "attempts", "last_error", "result", "created_at", "updated_at"}
+		q, found := queued[args[0].(int64)]
+		if !found {
+			return columns, nil
+		}
+		payload, _ := json.Marshal(q.job)
+		return columns, [][]driver.Value{{args[0], q.kind, string(payload), "done", int64(1), nil, `{"id":5}`, time.Now(), time.Now()}}
+	})
+	conn, _ := sql.Open("pgapi_rows", "")
+	defer conn.Close()
+
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = "api"
+	cfg.Auth.CookieName, cfg.Auth.Procedure, cfg.Auth.Separator = "sid", "auth_check", ":"
+	s := newTestService(cfg)
+	s.dbr = db.NewPool(conn, nil, "", 0, s.log)
+	s.queue = jobs.New(conn, config.Jobs{}, s.log)
+
+	for _, tst := range []struct {
+		name  string
+		path  string
+		token string
+		user  string
+		code  int
+	}{
+		{"owner", "/api/jobs/1", "t1", "alice", http.StatusOK},
+		{"no token", "/api/jobs/1", "", "alice", http.StatusNotFound},
+		{"wrong token", "/api/jobs/1", "t3", "alice", http.StatusNotFound},
+		{"other user", "/api/jobs/1", "t1", "bob", http.StatusNotFound},
+		{"not authenticated", "/api/jobs/1", "t1", "", http.StatusUnauthorized},
+		{"anonymous job", "/api/jobs/3", "t3", "bob", http.StatusOK},
+		{"not a finalize job", "/api/jobs/2", "t2", "bob", http.StatusNotFound},
+		{"unknown job", "/api/jobs/4", "t1", "alice", http.StatusNotFound},
+		{"invalid id", "/api/jobs/x", "t1", "alice", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tst.path, nil)
+		if tst.token != "" {
+			r.Header.Set(jobTokenHeader, tst.token)
+		}
+		if tst.user != "" {
+			r.AddCookie(&http.Cookie{Name: "sid", Value: tst.user})
+		}
+		w := httptest.NewRecorder()
+		s.JobHandler(w, r)
+		assert.Equal(t, tst.code, w.Code, tst.name)
+		if tst.code == http.StatusOK {
+			var st jobStatus
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &st), tst.name)
+			assert.Equal(t, "done", st.State, tst.name)
+			assert.JSONEq(t, `{"id":5}`, string(st.Result), tst.name)
+		}
+	}
+}
+
+func Test_CallbackURL(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Jobs.CallbackHosts = []string{"hooks.example.com"}
+	s := newTestService(cfg)
+	parsed := ParsedURL{MethodConfig: config.MethodConfig{Webhook: "https://webhook.example.com/done"}}
+
+	callback := func(header string) (string, error) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/orders", nil)
+		if header != "" {
+			r.Header.Set(defaultCallbackHeader, header)
+		}
+		return s.callbackURL(r, parsed)
+	}
+
+	// method webhook is used without the header
+	u, err := callback("")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://webhook.example.com/done", u)
+	// the header requires the job queue
+	_, err = callback("https://hooks.example.com/done")
+	assert.NotNil(t, err)
+
+	s.queue = jobs.New(nil, config.Jobs{}, s.log)
+	for _, tst := range []struct {
+		url string
+		ok  bool
+	}{
+		{"https://hooks.example.com/done?id=1", true},
+		{"http://HOOKS.example.com:8080/done", true},
+		{"ftp://hooks.example.com/done", false},
+		{"//hooks.example.com/done", false},
+		{"https:///done", false},
+		{"https://evil.com/done", false},
+		{"https://hooks.example.com.evil.com/done", false},
+		{"https://evil.com/?hooks.example.com", false},
+	} {
+		u, err := callback(tst.url)
+		if tst.ok {
+			assert.Nil(t, err, tst.url)
+			assert.Equal(t, tst.url, u)
+		} else {
+			assert.NotNil(t, err, tst.url)
+		}
+	}
+}
+
+func Test_CallbackJob(t *testing.T) {
+	var received, redirected int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		var st jobStatus
+		if json.NewDecoder(r.Body).Decode(&st) == nil && st.ID == 7 && r.Header.Get(jobIDHeader) == "7" {
+			atomic.AddInt32(&received, 1)
+		}
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/target", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&redirected, 1)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	s := newTestService(&config.Config{})
+
+	job := func(path string) jobs.Job {
+		payload, _ := json.Marshal(callbackJob{URL: srv.URL + path, Status: jobStatus{ID: 7, State: "done"}})
+		return jobs.Job{ID: 8, Kind: jobCallback, Payload: payload}
+	}
+
+	result, err := s.runCallbackJob(context.Background(), job("/hook"))
+	assert.Nil(t, err)
+	assert.Equal(t, "200", result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// redirects are not followed
+	_, err = s.runCallbackJob(context.Background(), job("/moved"))
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&redirected))
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bhmj/pg-api/internal/pkg/auth"
+	"github.com/bhmj/pg-api/internal/pkg/catalog"
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/str"
+)
+
+type obj = map[string]interface{}
+
+// openAPICache keeps OpenAPI document built for a catalog generation
+type openAPICache struct {
+	mx  sync.Mutex
+	gen uint64
+	doc []byte
+}
+
+// OpenAPIHandler serves OpenAPI document built from config and function catalog
+func (s *service) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" && s.cfg.HTTP.CORS {
+		s.allowCORS(w)
+		return
+	}
+	buf, err := s.openAPIDocument()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if s.cfg.HTTP.CORS {
+		s.allowCORS(w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.Write(buf)
+}
+
+// openAPIDocument returns OpenAPI document, rebuilding it only after the function catalog has changed
+func (s *service) openAPIDocument() ([]byte, error) {
+	c := &s.openapi
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	gen := s.catalog.Generation()
+	if c.doc != nil && c.gen == gen {
+		return c.doc, nil
+	}
+	doc, err := json.Marshal(s.buildOpenAPI())
+	if err != nil {
+		return nil, err
+	}
+	c.gen, c.doc = gen, doc
+	return doc, nil
+}
+
+// buildOpenAPI makes OpenAPI 3.1 document.
+// Paths are derived from function names using translation rules in reverse:
+// foo_bar_get_v2 -> GET /v2/foo/{foo_id}/bar/{bar_id}
+func (s *service) buildOpenAPI() obj {
+	paths := obj{}
+
+	// functions which are not API methods
+	skip := map[string]bool{
+		funcName(s.cfg.Auth.Procedure):  true,
+		funcName(s.cfg.Minio.Procedure): true,
+	}
+	for _, m := range s.cfg.Methods {
+		for _, fin := range m.FinalizeName {
+			skip[fin] = true
+		}
+	}
+
+	type schemaSource struct{ source, schema string }
+	schemas := []schemaSource{{s.db.readSource, s.db.readSchema}}
+	if s.db.writeSource != s.db.readSource || s.db.writeSchema != s.db.readSchema {
+		schemas = append(schemas, schemaSource{s.db.writeSource, s.db.writeSchema})
+	}
+	for _, src := range schemas {
+		for _, fn := range s.catalog.Functions(src.source, src.schema) {
+			base, _ := splitVersion(fn.Name)
+			if !skip[base] {
+				s.describeFunction(paths, src.source, src.schema, fn)
+			}
+		}
+	}
+
+	if s.f != nil {
+		describeFileEndpoints(paths)
+	}
+
+	doc := obj{
+		"openapi": "3.1.0",
+		"info": obj{
+			"title":   s.cfg.Service.Name,
+			"version": s.cfg.Service.Version,
+			"description": "API version may be passed in URL (/v2/...) or in `Accept-Version` header. " +
+				"If there is no function for the requested version, the newest previous version is called.",
+		},
+		"servers": []obj{{"url": "/" + s.cfg.HTTP.Endpoint}},
+		"paths":   paths,
+	}
+	if schemes, requirement := s.securitySchemes(); len(schemes) > 0 {
+		doc["components"] = obj{"securitySchemes": schemes}
+		doc["security"] = []obj{requirement}
+	}
+	return doc
+}
+
+// describeFunction adds API method implemented by the function into paths
+func (s *service) describeFunction(paths obj, source string, schema string, fn catalog.Function) {
+	base, ver := splitVersion(fn.Name)
+
+	// CRUD convention: name has a method suffix
+	httpMethod := ""
+	var props config.MethodConfig
+	if i := strings.LastIndex(base, "_"); i > 0 {
+		if m, found := methodBySuffix(base[i+1:]); found {
+			props = s.cfg.MethodProperties(methodPath(base[:i]), ver)
+			if props.Convention == "CRUD" {
+				httpMethod = m
+				base = base[:i]
+			}
+		}
+	}
+	// POST convention: no suffix
+	if httpMethod == "" {
+		props = s.cfg.MethodProperties(methodPath(base), ver)
+		if props.Convention != "POST" {
+			return // not an API function
+		}
+		httpMethod = "POST"
+	}
+	// function must be in the schema used for the method
+	if writeDB[httpMethod] && (source != s.db.writeSource || schema != s.db.writeSchema) ||
+		!writeDB[httpMethod] && (source != s.db.readSource || schema != s.db.readSchema) {
+		return
+	}
+
+	path := "/v" + strconv.Itoa(ver)
+	params := make([]obj, 0)
+	segments := strings.Split(base, "_")
+	for i, seg := range segments {
+		path += "/" + seg
+		if i < len(segments)-1 || props.Convention == "CRUD" && httpMethod != "POST" {
+			params = append(params, obj{
+				"name":        seg + "_id",
+				"in":          "path",
+				"required":    true,
+				"description": "object ID (0 if omitted)",
+				"schema":      obj{"type": "integer", "format": "int64"},
+			})
+			path += "/{" + seg + "_id}"
+		}
+	}
+	for _, h := range props.HeadersPass {
+		params = append(params, obj{"name": h.Header, "in": "header", "schema": obj{"type": "string"}})
+	}
+
+	response := obj{"description": str.Scoalesce(fn.Result, "function result")}
+	if httpCodes[httpMethod] != http.StatusNoContent {
+		response["content"] = obj{props.ContentType: obj{"schema": schemaDocument(props.ResponseSchema)}}
+	}
+	op := obj{
+		"operationId":    fn.Name,
+		"summary":        fn.Name,
+		"parameters":     params,
+		"responses":      obj{strconv.Itoa(httpCodes[httpMethod]): response, "default": obj{"description": "error"}},
+		"x-pg-function":  schema + "." + fn.Name,
+		"x-pg-arguments": fn.Arguments,
+	}
+	if fn.Comment != "" {
+		op["description"] = fn.Comment
+	}
+	if hasBody[httpMethod] {
+		op["requestBody"] = obj{"content": obj{"application/json": obj{"schema": schemaDocument(props.RequestSchema)}}}
+	}
+	if props.Deprecation.Covers(ver) {
+		op["deprecated"] = true
+	}
+
+	item, _ := paths[path].(obj)
+	if item == nil {
+		item = obj{}
+		paths[path] = item
+	}
+	item[strings.ToLower(httpMethod)] = op
+}
+
+// securitySchemes returns auth schemes in use and the requirement for all methods
+func (s *service) securitySchemes() (schemes obj, requirement obj) {
+	schemes, requirement = obj{}, obj{}
+	if s.cfg.Auth.CookieName != "" {
+		schemes["cookieAuth"] = obj{"type": "apiKey", "in": "cookie", "name": s.cfg.Auth.CookieName}
+		requirement["cookieAuth"] = []string{}
+	}
+	if len(s.cfg.HTTP.AccessFiles) > 0 {
+		schemes["keySign"] = obj{"type": "apiKey", "in": "header", "name": auth.KeyHeader}
+		schemes["keyID"] = obj{"type": "apiKey", "in": "header", "name": auth.CallerHeader}
+		requirement["keySign"] = []string{}
+		requirement["keyID"] = []string{}
+	}
+	return
+}
+
+func describeFileEndpoints(paths obj) {
+	paths["/file/"] = obj{
+		"post": obj{
+			"summary": "upload files",
+			"requestBody": obj{"content": obj{"multipart/form-data": obj{"schema": obj{
+				"type":       "object",
+				"required":   []string{"bucket"},
+				"properties": obj{"bucket": obj{"type": "string"}, "file": obj{"type": "string", "format": "binary"}},
+			}}}},
+			"responses": obj{"200": obj{"description": "uploaded file paths"}, "default": obj{"description": "error"}},
+		},
+	}
+	paths["/file/{bucket}/{object}"] = obj{
+		"get": obj{
+			"summary": "download file",
+			"parameters": []obj{
+				{"name": "bucket", "in": "path", "required": true, "schema": obj{"type": "string"}},
+				{"name": "object", "in": "path", "required": true, "schema": obj{"type": "string"}},
+			},
+			"responses": obj{"200": obj{"description": "file contents", "content": obj{"application/octet-stream": obj{}}}},
+		},
+	}
+}
+
+// schemaDocument returns JSON Schema from config (inline or file) or a generic schema
+func schemaDocument(schema interface{}) interface{} {
+	var doc []byte
+	switch v := schema.(type) {
+	case nil:
+		return obj{}
+	case string:
+		if strings.HasPrefix(strings.TrimSpace(v), "{") {
+			doc = []byte(v)
+		} else {
+			doc, _ = ioutil.ReadFile(v)
+		}
+	default:
+		return v
+	}
+	var result interface{}
+	if json.Unmarshal(doc, &result) != nil {
+		return obj{}
+	}
+	return result
+}
+
+// splitVersion splits function name into base name and version: foo_get_v2 -> foo_get, 2
+func splitVersion(name string) (string, int) {
+	if m := regexpMap["versionSuffix"].FindStringSubmatch(name); m != nil {
+		ver, _ := strconv.Atoi(m[2])
+		return m[1], ver
+	}
+	return name, 1
+}
+
+func methodBySuffix(suffix string) (string, bool) {
+	for method, sfx := range suffixMap {
+		if sfx == suffix && method != "HIT" {
+			return method, true
+		}
+	}
+	return "", false
+}
+
+// methodPath returns method path for function base name: foo_bar -> /foo/bar/
+func methodPath(base string) string {
+	return "/" + strings.ReplaceAll(base, "_", "/") + "/"
+}
+
+// funcName strips schema from function name
+func funcName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
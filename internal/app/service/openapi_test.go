@@ -0,0 +1,91 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/catalog"
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newOpenAPIService(convention string) *service {
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = "api"
+	cfg.Service.Name, cfg.Service.Version = "orders", "1.0.0"
+	cfg.General.Convention = convention
+	cfg.General.ContentType = "application/json"
+	cfg.Auth.Procedure = "api.auth_check"
+	s := newTestService(cfg)
+	s.db = &dbGroup{readSource: catalogRead, readSchema: "api", writeSource: catalogRead, writeSchema: "api"}
+	return s
+}
+
+func Test_DescribeFunction(t *testing.T) {
+	s := newOpenAPIService("CRUD")
+	paths := obj{}
+	s.describeFunction(paths, catalogRead, "api", catalog.Function{Name: "order_item_get_v2", Arguments: "_id bigint", Result: "json", Comment: "order item"})
+	s.describeFunction(paths, catalogRead, "api", catalog.Function{Name: "order_ins"})
+	s.describeFunction(paths, catalogRead, "api", catalog.Function{Name: "order_del"})
+	s.describeFunction(paths, catalogRead, "api", catalog.Function{Name: "calc_total"}) // no method suffix
+
+	get := paths["/v2/order/{order_id}/item/{item_id}"].(obj)["get"].(obj)
+	assert.Equal(t, "order_item_get_v2", get["operationId"])
+	assert.Equal(t, "api.order_item_get_v2", get["x-pg-function"])
+	assert.Equal(t, "_id bigint", get["x-pg-arguments"])
+	assert.Equal(t, "order item", get["description"])
+	assert.Len(t, get["parameters"], 2)
+	assert.Contains(t, get["responses"], "200")
+	assert.Nil(t, get["requestBody"])
+
+	post := paths["/v1/order"].(obj)["post"].(obj)
+	assert.Contains(t, post["responses"], "201")
+	assert.NotNil(t, post["requestBody"])
+	assert.Len(t, post["parameters"], 0)
+
+	del := paths["/v1/order/{order_id}"].(obj)["delete"].(obj)
+	assert.Nil(t, del["responses"].(obj)["204"].(obj)["content"]) // no content
+
+	assert.Len(t, paths, 3)
+
+	// the function is called from another schema
+	s.db.writeSource, s.db.writeSchema = catalogWrite, "api_w"
+	paths = obj{}
+	s.describeFunction(paths, catalogRead, "api", catalog.Function{Name: "order_ins"})
+	assert.Len(t, paths, 0)
+	s.describeFunction(paths, catalogWrite, "api_w", catalog.Function{Name: "order_ins"})
+	assert.Len(t, paths, 1)
+
+	// POST convention
+	s = newOpenAPIService("POST")
+	paths = obj{}
+	s.describeFunction(paths, catalogRead, "api", catalog.Function{Name: "calc_total"})
+	s.describeFunction(paths, catalogRead, "api", catalog.Function{Name: "order_get"})
+	assert.NotNil(t, paths["/v1/calc/{calc_id}/total"].(obj)["post"])
+	assert.NotNil(t, paths["/v1/order/{order_id}/get"].(obj)["post"])
+}
+
+func Test_BuildOpenAPI(t *testing.T) {
+	s := newOpenAPIService("CRUD")
+	s.cfg.HTTP.AccessFiles = []string{"keys.txt"}
+	s.catalog.Set(catalogRead, "api", []catalog.Function{{Name: "order_get"}, {Name: "order_get_v2"}, {Name: "auth_check"}})
+
+	doc := s.buildOpenAPI()
+	assert.Equal(t, "3.1.0", doc["openapi"])
+	assert.Equal(t, "orders", doc["info"].(obj)["title"])
+	assert.Equal(t, []obj{{"url": "/api"}}, doc["servers"])
+	paths := doc["paths"].(obj)
+	assert.Len(t, paths, 2) // auth procedure is not an API method
+	assert.NotNil(t, paths["/v1/order/{order_id}"])
+	assert.NotNil(t, paths["/v2/order/{order_id}"])
+	assert.Contains(t, doc["components"].(obj)["securitySchemes"], "keySign")
+
+	// the document is rebuilt only when the catalog changes
+	buf, err := s.openAPIDocument()
+	assert.Nil(t, err)
+	s.catalog.Set(catalogRead, "api", []catalog.Function{{Name: "order_get"}, {Name: "order_get_v2"}, {Name: "auth_check"}})
+	same, _ := s.openAPIDocument()
+	assert.True(t, &buf[0] == &same[0])
+	s.catalog.Set(catalogRead, "api", []catalog.Function{{Name: "order_get"}})
+	changed, _ := s.openAPIDocument()
+	assert.NotEqual(t, string(buf), string(changed))
+}
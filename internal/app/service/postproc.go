@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/backoff"
+	"github.com/bhmj/pg-api/internal/pkg/jobs"
+)
+
+const jobPostproc = "postproc"
+
+// in-memory post-processing delivery (job queue settings are used when it is enabled)
+const (
+	postprocAttempts   = 5
+	postprocBackoff    = 1 * time.Second
+	postprocMaxBackoff = 30 * time.Second
+)
+
+// postprocJob contains everything needed to run post-processing outside of the request
+type postprocJob struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"` // method path without version
+	Version int    `json:"version"`
+	UserID  int64  `json:"user_id,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Shard   string `json:"shard,omitempty"`
+	ID      int64  `json:"id"`     // main function result ID
+	Result  string `json:"result"` // main or finalizing function result
+}
+
+// startPostproc runs post-processing in the background: in the job queue if enabled, in memory otherwise
+func (s *service) startPostproc(parsed ParsedURL, grp *dbGroup, result []byte, in http.Header, id int64) {
+	if len(parsed.Postproc) == 0 {
+		return
+	}
+	if s.queue != nil {
+		job := postprocJob{
+			Method:  parsed.Method,
+			Path:    parsed.Path,
+			Version: parsed.Version,
+			UserID:  parsed.UserID,
+			Tenant:  grp.tenant,
+			Shard:   grp.shard,
+			ID:      id,
+			Result:  string(result),
+		}
+		_, err := s.queue.Enqueue(s.ctx, jobPostproc, job)
+		if err == nil {
+			return
+		}
+		s.log.L().Errorf("postproc %s id=%d: job queue: %s, running in memory", parsed.MethodPath, id, err.Error())
+	}
+	s.acquireGroup(grp)
+	started := s.background(fmt.Sprintf("postproc %s id=%d", parsed.MethodPath, id), func() {
+		defer s.releaseGroup(grp)
+		_ = s.postprocess(parsed, grp, result, in, id, postprocAttempts)
+	})
+	if !started {
+		s.releaseGroup(grp)
+	}
+}
+
+// runPostprocJob is the job queue handler for post-processing. Failed jobs are retried as a whole.
+func (s *service) runPostprocJob(ctx context.Context, j jobs.Job) (string, error) {
+	var job postprocJob
+	if err := json.Unmarshal(j.Payload, &job); err != nil {
+		return "", err
+	}
+	parsed, err := s.parseURL(job.Method, job.Path, job.Version, s.cfg)
+	if err != nil {
+		return "", err
+	}
+	parsed.UserID = job.UserID
+	grp, err := s.jobGroup(job.Tenant, job.Shard)
+	if err != nil {
+		return "", err
+	}
+	defer s.releaseGroup(grp)
+	return "", s.postprocess(parsed, grp, []byte(job.Result), nil, job.ID, 1) // incoming headers are not stored with jobs
+}
+
+// postprocess calls Postproc external services and stores the enriched result with PostprocFunction.
+// Post-processing is made up to attempts times: it fails if a step with OnError fail fails or the function call fails.
+func (s *service) postprocess(parsed ParsedURL, grp *dbGroup, result []byte, in http.Header, id int64, attempts int) error {
+	err := s.postprocessOnce(parsed, grp, result, in, id)
+	for attempt := 1; err != nil && attempt < attempts; attempt++ {
+		s.log.L().Warnf("postproc %s id=%d: %s, retry %d of %d", parsed.MethodPath, id, err.Error(), attempt, attempts-1)
+		select {
+		case <-s.ctx.Done():
+			s.log.L().Errorf("postproc %s id=%d: %s, service is stopping", parsed.MethodPath, id, err.Error())
+			return err
+		case <-time.After(backoff.Delay(attempt, postprocBackoff, postprocMaxBackoff)):
+		}
+		err = s.postprocessOnce(parsed, grp, result, in, id)
+	}
+	if err != nil {
+		s.log.L().Errorf("postproc %s id=%d: %s", parsed.MethodPath, id, err.Error())
+	}
+	return err
+}
+
+// postprocessOnce does a post-processing attempt.
+// PostprocFunction is not called if Postproc steps have not changed the result (all of them failed or were skipped).
+func (s *service) postprocessOnce(parsed ParsedURL, grp *dbGroup, result []byte, in http.Header, id int64) error {
+	enriched, _, eerr := s.enhanceData(result, parsed.Postproc, in, defaultBackgroundTimeout, parsed.MethodConfig)
+	if eerr != nil {
+		return eerr
+	}
+	if parsed.PostprocFunction == "" {
+		return nil
+	}
+	var orig interface{}
+	if json.Unmarshal(result, &orig) == nil {
+		if buf, _ := json.Marshal(orig); bytes.Equal(buf, enriched) {
+			s.log.L().Infof("postproc %s id=%d: result is not enriched, %s is not called", parsed.MethodPath, id, parsed.PostprocFunction)
+			return nil
+		}
+	}
+
+	schema := grp.writeSchema
+	name, _ := s.catalog.Resolve(grp.writeSource, schema, parsed.PostprocFunction, parsed.Version)
+	query := "select * from " + schema + "." + name + " ($1, $2)"
+	if err := s.execDBRequest(grp.dbw, query, []interface{}{id, string(enriched)}); err != nil {
+		return fmt.Errorf("postproc query: %s: %w", query, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// execDriver is a database driver recording arguments of executed queries
+type execDriver struct {
+	mx    sync.Mutex
+	calls [][]driver.Value
+	fail  int // number of calls to fail
+}
+
+func (d *execDriver) Open(string) (driver.Conn, error) { return &execConn{d}, nil }
+
+func (d *execDriver) reset(fail int) {
+	d.mx.Lock()
+	d.calls, d.fail = nil, fail
+	d.mx.Unlock()
+}
+
+type execConn struct{ d *execDriver }
+
+func (c *execConn) Prepare(query string) (driver.Stmt, error) { return &execStmt{c.d}, nil }
+func (c *execConn) Close() error                              { return nil }
+func (c *execConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type execStmt struct{ d *execDriver }
+
+func (s *execStmt) Close() error  { return nil }
+func (s *execStmt) NumInput() int { return -1 }
+func (s *execStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mx.Lock()
+	defer s.d.mx.Unlock()
+	s.d.calls = append(s.d.calls, args)
+	if s.d.fail > 0 {
+		s.d.fail--
+		return nil, errors.New("database is down")
+	}
+	return &execRows{}, nil
+}
+func (s *execStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mx.Lock()
+	defer s.d.mx.Unlock()
+	s.d.calls = append(s.d.calls, args)
+	if s.d.fail > 0 {
+		s.d.fail--
+		return nil, errors.New("database is down")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// execRows is a single row query result
+type execRows struct{ done bool }
+
+func (r *execRows) Columns() []string { return []string{"result"} }
+func (r *execRows) Close() error      { return nil }
+func (r *execRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = `{"id":1}`
+	return nil
+}
+
+var testDriver = &execDriver{}
+
+func init() {
+	sql.Register("pgapi_exec", testDriver)
+}
+
+func Test_Postprocess(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"v":1}`))
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	dbw, _ := sql.Open("pgapi_exec", "")
+	defer dbw.Close()
+	s := newTestService(&config.Config{})
+	grp := &dbGroup{dbw: dbw, writeSchema: "api"}
+
+	parsed := func(path string, onError string) ParsedURL {
+		return ParsedURL{MethodPath: "/orders/", Version: 1, MethodConfig: config.MethodConfig{
+			Postproc:         []config.Enhance{{URL: srv.URL + path, OnError: onError, TransferFields: []config.TransferFields{{From: "$.v", To: "v"}}}},
+			PostprocFunction: "orders_postproc",
+		}}
+	}
+
+	// enriched result is delivered
+	testDriver.reset(0)
+	err := s.postprocess(parsed("/ok", ""), grp, []byte(`{"id":5}`), nil, 5, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]driver.Value{{int64(5), `{"id":5,"v":1}`}}, testDriver.calls)
+
+	// the step failed: nothing to store
+	testDriver.reset(0)
+	err = s.postprocess(parsed("/down", ""), grp, []byte(`{"id":5}`), nil, 5, 1)
+	assert.Nil(t, err)
+	assert.Len(t, testDriver.calls, 0)
+
+	// the step failed with OnError fail: the job is retried
+	testDriver.reset(0)
+	err = s.postprocess(parsed("/down", config.OnErrorFail), grp, []byte(`{"id":5}`), nil, 5, 1)
+	assert.NotNil(t, err)
+	assert.Len(t, testDriver.calls, 0)
+
+	// the function call failed
+	testDriver.reset(1)
+	err = s.postprocess(parsed("/ok", ""), grp, []byte(`{"id":5}`), nil, 5, 1)
+	assert.NotNil(t, err)
+	assert.Len(t, testDriver.calls, 1)
+
+	// retries are stopped with the service
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.ctx = ctx
+	testDriver.reset(5)
+	err = s.postprocess(parsed("/ok", ""), grp, []byte(`{"id":5}`), nil, 5, postprocAttempts)
+	assert.NotNil(t, err)
+	assert.Len(t, testDriver.calls, 1)
+}
@@ -3,6 +3,8 @@ package service
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/bhmj/pg-api/internal/pkg/config"
 	phttp "github.com/bhmj/pg-api/internal/pkg/http"
+	"github.com/bhmj/pg-api/internal/pkg/limits"
 	"github.com/bhmj/pg-api/internal/pkg/str"
 )
 
@@ -24,13 +27,40 @@ type queryResult struct {
 
 func (s *service) processQuery(w http.ResponseWriter, r *http.Request) (code int, err error) {
 	code = http.StatusBadRequest
+	// tenant
+	grp, err := s.dbGroup(r)
+	if err != nil {
+		code = http.StatusNotFound
+		if err != errUnknownTenant {
+			code = http.StatusInternalServerError
+		}
+		return
+	}
+	defer s.releaseGroup(grp)
+	if grp.tenant != "" {
+		defer s.metrics.ScoreTenant(grp.tenant, s.method, s.vpath, time.Now(), &err)
+	}
+
 	// parse URL
-	parsed, err := s.parseURL(s.path, s.version, s.cfg)
+	parsed, err := s.parseURL(s.method, s.path, s.version, s.cfg)
 	if err != nil {
 		return
 	}
+	parsed.UserID = s.userID
 
-	body, _ := ioutil.ReadAll(r.Body)
+	// deprecated versions
+	if s.signalDeprecation(w, r, &parsed) {
+		code = http.StatusGone
+		err = errors.New("method version is no longer supported")
+		return
+	}
+
+	// request limits
+	body, status, err := readBody(r, parsed.Limits)
+	if err != nil {
+		code = status
+		return
+	}
 
 	// headers pass-through
 	var headers []phttp.HeaderValue
@@ -57,28 +87,73 @@ func (s *service) processQuery(w http.ResponseWriter, r *http.Request) (code int
 		}
 	}
 
-	// enhance if needed (only for standard scenario)
-	if len(parsed.FinalizeName) == 0 && len(parsed.Enhance) > 0 && s.method == "POST" {
-		// pre-processing
-		body = s.enhanceData(body, parsed.Enhance, 1*time.Second) // TODO: customize external service call timeout (config?)
+	// request validation
+	if parsed.RequestValidator != nil && hasBody[s.method] {
+		if err = validateJSON(parsed.RequestValidator, body); err != nil {
+			code = http.StatusUnprocessableEntity
+			return
+		}
 	}
 
-	db := s.dbr
-	schema := s.cfg.DBGroup.Read.Schema
-	if writeDB[s.method] {
-		db = s.dbw
-		schema = s.cfg.DBGroup.Write.Schema
+	// client callback for finalize scenario
+	var callback string
+	if len(parsed.FinalizeName) > 0 {
+		if callback, err = s.callbackURL(r, parsed); err != nil {
+			return
+		}
 	}
 
-	// prepare main function
-	query := s.prepareSQL(schema, parsed, string(body), headers, 0)
+	// enhance request if needed (only for standard scenario)
+	in := incomingHeaders(parsed.MethodConfig, r.Header)
+	var enriched []string
+	if len(parsed.FinalizeName) == 0 {
+		if enhance := stageSteps(parsed.Enhance, config.StageRequest); len(enhance) > 0 {
+			// pre-processing
+			var eerr *enhanceError
+			body, enriched, eerr = s.enhanceData(body, enhance, in, defaultEnhanceTimeout, parsed.MethodConfig)
+			if eerr != nil {
+				setEnriched(w, enriched)
+				code, err = eerr.status, eerr.apiError()
+				return
+			}
+		}
+	}
 
-	// call main function
-	var result string
-	err = s.makeDBRequest(db, query, &result)
-	if err != nil {
-		code = http.StatusInternalServerError
-		return
+	var query, result string
+	if parsed.FanOut != "" && !writeDB[s.method] && len(s.shards) > 0 && !grp.own {
+		// call main function on every shard (reads only, writes are routed by shard key)
+		result, query, err = s.fanOut(parsed, grp, body, headers)
+		if err != nil {
+			code = http.StatusInternalServerError
+			return
+		}
+	} else {
+		// shard
+		grp, err = s.shardGroup(r, parsed, body, grp)
+		if err != nil {
+			return
+		}
+
+		db := s.readDB(r, grp)
+		source, schema := grp.readSource, grp.readSchema
+		if writeDB[s.method] {
+			db = grp.dbw
+			source, schema = grp.writeSource, grp.writeSchema
+		}
+
+		// prepare main function
+		var args []interface{}
+		query, args = s.prepareSQL(source, schema, parsed, string(body), headers, 0)
+
+		// call main function
+		err = s.makeDBRequest(db, query, args, &result)
+		if err != nil {
+			code = http.StatusInternalServerError
+			return
+		}
+		if writeDB[s.method] {
+			s.markWrite(w, grp.dbw)
+		}
 	}
 
 	// error + http code from query
@@ -100,41 +175,39 @@ func (s *service) processQuery(w http.ResponseWriter, r *http.Request) (code int
 	}
 
 	rawResult := []byte(result)
+	// enhance response if needed
+	if enhance := stageSteps(parsed.Enhance, config.StageResponse); len(enhance) > 0 && qRes.Error == "" {
+		var fields []string
+		var eerr *enhanceError
+		rawResult, fields, eerr = s.enhanceData(rawResult, enhance, in, defaultEnhanceTimeout, parsed.MethodConfig)
+		switch {
+		case eerr == nil:
+			enriched = append(enriched, fields...)
+		case writeDB[s.method]:
+			// the write is committed already: the result is returned as is with its status
+			s.log.L().Errorf("%s %s: response not enriched: %s", s.method, parsed.MethodPath, eerr.Error())
+		default:
+			setEnriched(w, append(enriched, fields...))
+			code, err = eerr.status, eerr.apiError()
+			return
+		}
+	}
+	// response validation (debug mode)
+	if parsed.ResponseValidator != nil && s.cfg.LogLevel >= 3 { // verbose
+		if verr := validateJSON(parsed.ResponseValidator, rawResult); verr != nil {
+			details, _ := json.Marshal(verr)
+			s.log.L().Warnf("response validation: %s, query: %s", string(details), query)
+		}
+	}
 	if len(parsed.FinalizeName) == 0 {
 		// standard scenario: post-processing
-		if len(parsed.Postproc) > 0 && s.method == "POST" {
-			go func(rawRes []byte, postproc []config.Enhance) {
-				_ = s.enhanceData(rawRes, postproc, 60*time.Second)
-			}(rawResult, parsed.Postproc)
-		}
+		s.startPostproc(parsed, grp, rawResult, in, qRes.ID)
 	} else {
 		// fast scenario: return id from main function and do the pre- and post-processing in the background
-		go func(
-			rawBody []byte,
-			parsed ParsedURL,
-			id int64,
-		) {
-			var body []byte
-			var result string
-
-			if len(parsed.Enhance) > 0 && s.method == "POST" {
-				// pre-processing
-				body = s.enhanceData(rawBody, parsed.Enhance, 60*time.Second)
-			}
-
-			// finalizing query
-			query := s.prepareSQL(s.cfg.DBGroup.Write.Schema, parsed, string(body), headers, id)
-			err = s.makeDBRequest(s.dbw, query, &result)
-			if err != nil {
-				s.log.L().Errorf("finalizing query: %s, error: %s", query, err.Error())
-			} else {
-				s.log.L().Infof("finalizing query result: %s", result)
-			}
-			if len(parsed.Postproc) > 0 && s.method == "POST" {
-				// post-processing
-				_ = s.enhanceData([]byte(result), parsed.Postproc, 60*time.Second)
-			}
-		}(body, parsed, qRes.ID)
+		if jobID, token := s.startFinalize(parsed, grp, body, headers, in, qRes.ID, callback); jobID > 0 {
+			w.Header().Set(jobIDHeader, strconv.FormatInt(jobID, 10))
+			w.Header().Set(jobTokenHeader, token)
+		}
 	}
 
 	// http response code
@@ -146,6 +219,7 @@ func (s *service) processQuery(w http.ResponseWriter, r *http.Request) (code int
 	if s.cfg.HTTP.CORS {
 		s.allowCORS(w)
 	}
+	setEnriched(w, enriched)
 	w.Header().Set("Content-Type", str.Scoalesce(parsed.ContentType, "application/json"))
 	w.Header().Set("Content-Length", strconv.Itoa(len(rawResult)))
 	w.WriteHeader(code)
@@ -155,8 +229,43 @@ func (s *service) processQuery(w http.ResponseWriter, r *http.Request) (code int
 	return
 }
 
-func (s *service) parseURL(urlpath string, version int, cfg *config.Config) (parsed ParsedURL, err error) {
-	parsed = ParsedURL{}
+// setEnriched lists fields enriched by Enhance steps in the response header
+func setEnriched(w http.ResponseWriter, enriched []string) {
+	if len(enriched) > 0 {
+		w.Header().Set(enrichedHeader, strings.Join(enriched, ","))
+	}
+}
+
+// readBody reads request body checking its size and JSON structure against the limits.
+// HTTP status code is returned along with an error.
+func readBody(r *http.Request, l config.Limits) (body []byte, code int, err error) {
+	code = http.StatusRequestEntityTooLarge
+	if l.BodySize > 0 && r.ContentLength > l.BodySize {
+		err = fmt.Errorf("request body exceeds %d bytes", l.BodySize)
+		return
+	}
+	var rd io.Reader = r.Body
+	if l.BodySize > 0 {
+		rd = io.LimitReader(r.Body, l.BodySize+1)
+	}
+	body, err = ioutil.ReadAll(rd)
+	if err != nil {
+		code = http.StatusBadRequest
+		return
+	}
+	if l.BodySize > 0 && int64(len(body)) > l.BodySize {
+		err = fmt.Errorf("request body exceeds %d bytes", l.BodySize)
+		return
+	}
+	if err = limits.CheckJSON(body, l); err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	return body, 0, nil
+}
+
+func (s *service) parseURL(method string, urlpath string, version int, cfg *config.Config) (parsed ParsedURL, err error) {
+	parsed = ParsedURL{Path: urlpath, Method: method, Version: version}
 	var rx = regexpMap["parseUrl"]
 	if !rx.MatchString(urlpath) {
 		return parsed, errors.New("invalid url")
@@ -179,10 +288,10 @@ func (s *service) parseURL(urlpath string, version int, cfg *config.Config) (par
 
 	id := parsed.ID[len(parsed.ID)-1]
 
-	if id != 0 && s.method == "POST" {
+	if id != 0 && method == "POST" {
 		err = errors.New("unnecessary item ID in POST query")
 	}
-	if id == 0 && (s.method == "PUT" || s.method == "PATCH" || s.method == "DELETE") {
+	if id == 0 && (method == "PUT" || method == "PATCH" || method == "DELETE") {
 		err = errors.New("item ID required")
 	}
 
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RequestLimits(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = "api"
+	cfg.General.Limits = config.Limits{BodySize: 16, Depth: 2}
+	s := newTestService(cfg)
+
+	call := func(body io.Reader) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/orders", body)
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.MainHandler(w, r)
+		return w
+	}
+
+	// declared size over the limit: the body is not read
+	w := call(strings.NewReader(`{"a":"0123456789abcdef"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// streamed body of unknown size over the limit
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte(`{"a":"0123456789`))
+		pw.Write([]byte(`abcdef"}`))
+		pw.Close()
+	}()
+	w = call(pr)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// too deep
+	w = call(strings.NewReader(`{"a":{"b":{}}}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -2,19 +2,21 @@ package service
 
 import (
 	"database/sql"
-	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bhmj/pg-api/internal/pkg/db"
 	phttp "github.com/bhmj/pg-api/internal/pkg/http"
-	"github.com/bhmj/pg-api/internal/pkg/str"
 )
 
-// prepareSQL prepares SQL
-func (s *service) prepareSQL(schema string, parsed ParsedURL, body string, headers []phttp.HeaderValue, id int64) (query string) {
-	suffix := suffixMap[s.method]
+// prepareSQL prepares SQL and its arguments.
+// Query text depends only on the function name and the number of arguments,
+// so it is prepared once per connection and then taken from the statement cache.
+// Function versions are resolved using the catalog of the given source database.
+func (s *service) prepareSQL(source string, schema string, parsed ParsedURL, body string, headers []phttp.HeaderValue, id int64) (query string, args []interface{}) {
+	suffix := suffixMap[parsed.Method]
 	var functionName string
 	//id > 0 indicates that the finalizing SQL query is prepared
 	if id > 0 {
@@ -29,45 +31,46 @@ func (s *service) prepareSQL(schema string, parsed ParsedURL, body string, heade
 		suffix = "ins" // use last ID in function call
 	}
 
-	// complete SQL query
-	var arguments []string
-	if s.userID > 0 {
-		arguments = append(arguments, fmt.Sprintf("%d", s.userID))
+	// function arguments
+	if id > 0 {
+		args = append(args, id) // Insert id into the first position of parameters list
+	}
+	if parsed.UserID > 0 {
+		args = append(args, parsed.UserID)
 	}
 	if len(headers) > 0 {
-		arguments = append(arguments, serializeHeaders(headers)...)
+		args = append(args, serializeHeaders(headers)...)
 	}
-	if len(parsed.ID) > 1 {
-		arguments = append(arguments, str.CommaSeparatedString(parsed.ID[0:len(parsed.ID)-1]))
+	for _, parentID := range parsed.ID[0 : len(parsed.ID)-1] {
+		args = append(args, parentID)
 	}
 	if suffix != "ins" {
-		arguments = append(arguments, fmt.Sprintf("%d", parsed.ID[len(parsed.ID)-1]))
+		args = append(args, parsed.ID[len(parsed.ID)-1])
 	}
 	if suffix != "del" && len(body) > 0 {
-		arguments = append(arguments, fmt.Sprintf("'%s'", sanitizeString(body)))
+		args = append(args, body)
 	}
 
-	functionParams := strings.Join(arguments, ", ")
-	if id > 0 {
-		functionParams = strconv.FormatInt(id, 10) + ", " + functionParams // Insert id into the first position of parameters list
+	placeholders := make([]string, len(args))
+	for i := range args {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
 	}
 
-	ver := ""
-	if s.version > 1 {
-		ver = "_v" + strconv.Itoa(s.version)
-	}
-	query = "select * from " + schema + "." + functionName + ver + " (" + functionParams + ")"
+	// the newest existing function version not greater than requested one
+	functionName, _ = s.catalog.Resolve(source, schema, functionName, parsed.Version)
+	query = "select * from " + schema + "." + functionName + " (" + strings.Join(placeholders, ", ") + ")"
 
-	s.log.L().Infof("%s", query)
+	s.log.L().Infof("%s %v", query, args)
 
 	return
 }
 
 // makeDBRequest performs request to database
-func (s *service) makeDBRequest(db *sql.DB, query string, result *string) (err error) {
+func (s *service) makeDBRequest(db *sql.DB, query string, args []interface{}, result *string) (err error) {
 	t := time.Now()
 	defer s.metrics.Score(s.method, s.vpath, "db", t, &err)
-	rows, err := db.Query(query)
+	defer s.watchQuery(query, t, &err)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return
 	}
@@ -77,8 +80,39 @@ func (s *service) makeDBRequest(db *sql.DB, query string, result *string) (err e
 	return
 }
 
-func serializeHeaders(headers []phttp.HeaderValue) []string {
-	result := make([]string, 0)
+// execDBRequest performs request to database ignoring its result
+func (s *service) execDBRequest(db *sql.DB, query string, args []interface{}) (err error) {
+	t := time.Now()
+	defer s.metrics.Score(s.method, s.vpath, "db", t, &err)
+	defer s.watchQuery(query, t, &err)
+	_, err = db.Exec(query, args...)
+	return
+}
+
+// watchQuery counts query errors by SQLSTATE class and logs slow queries
+func (s *service) watchQuery(query string, begin time.Time, err *error) {
+	if *err != nil {
+		s.metrics.DBError(s.vpath, db.ErrorClass(*err))
+	}
+	threshold := time.Duration(s.cfg.DBGroup.SlowQuery) * time.Millisecond
+	if elapsed := time.Since(begin); threshold > 0 && elapsed > threshold {
+		s.log.L().Warnf("slow query: function %s, duration %s", queryFunction(query), elapsed)
+	}
+}
+
+// queryFunction returns function name from the query: "select * from api.foo_get ($1)" -> "api.foo_get"
+func queryFunction(query string) string {
+	if i := strings.Index(query, " from "); i >= 0 {
+		query = query[i+6:]
+	}
+	if i := strings.Index(query, " ("); i >= 0 {
+		query = query[:i]
+	}
+	return query
+}
+
+func serializeHeaders(headers []phttp.HeaderValue) []interface{} {
+	result := make([]interface{}, 0)
 	numKey := map[string]bool{"int": true, "integer": true, "bigint": true, "float": true, "number": true}
 	strKey := map[string]bool{"text": true, "string": true, "varchar": true}
 	for i := range headers {
@@ -89,16 +123,12 @@ func serializeHeaders(headers []phttp.HeaderValue) []string {
 		case numKey[strings.ToLower(headers[i].Type)]:
 			result = append(result, sanitizeNumber(headers[i].Value))
 		case strKey[strings.ToLower(headers[i].Type)]:
-			result = append(result, "'"+sanitizeString(headers[i].Value+"'"))
+			result = append(result, headers[i].Value)
 		}
 	}
 	return result
 }
 
-func sanitizeString(s string) string {
-	return strings.Replace(s, "'", "''", -1)
-}
-
 func sanitizeNumber(s string) string {
 	reg := regexp.MustCompile("[^0-9Ee.-]+")
 	result := reg.ReplaceAllString(s, "")
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq" // previous driver, for comparison
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/db"
+)
+
+// Run with a real database (the benchmark creates pgapi_bench schema):
+//   PGAPI_BENCH_DB="host=localhost dbname=postgres user=postgres" go test -run none -bench . ./internal/app/service/
+
+const benchBody = `{"name":"foo","tags":["a","b","c"],"nested":{"x":1,"y":2.5,"z":null}}`
+
+func benchDB(b *testing.B) string {
+	connStr := os.Getenv("PGAPI_BENCH_DB")
+	if connStr == "" {
+		b.Skip("PGAPI_BENCH_DB is not set")
+	}
+	conn, err := db.SetupDatabase(config.Database{ConnString: connStr})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close(conn)
+	_, err = conn.Exec(`
+		create schema if not exists pgapi_bench;
+		create or replace function pgapi_bench.bench_ins(_body jsonb) returns jsonb language sql as $$
+			select jsonb_build_object('id', 1, 'body', _body)
+		$$`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return connStr
+}
+
+// lib/pq with literal SQL: every call is parsed and planned by the server
+func Benchmark_LibPQ(b *testing.B) {
+	conn, err := sql.Open("postgres", benchDB(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	b.RunParallel(func(pb *testing.PB) {
+		var result string
+		for pb.Next() {
+			query := fmt.Sprintf("select * from pgapi_bench.bench_ins('%s'::jsonb)", benchBody)
+			if err := conn.QueryRow(query).Scan(&result); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// pgx via prepareSQL and makeDBRequest: the statement is prepared once per connection
+func Benchmark_Query(b *testing.B) {
+	conn, err := db.SetupDatabase(config.Database{ConnString: benchDB(b)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close(conn)
+	s := newTestService(config.New())
+	parsed := ParsedURL{QueryPath: "bench", Method: "POST", Version: 1, ID: []int64{0}}
+	parsed.Convention = "CRUD"
+	b.RunParallel(func(pb *testing.PB) {
+		var result string
+		for pb.Next() {
+			query, args := s.prepareSQL(catalogRead, "pgapi_bench", parsed, benchBody, nil, 0)
+			if err := s.makeDBRequest(conn, query, args, &result); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
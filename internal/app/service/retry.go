@@ -0,0 +1,235 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/backoff"
+	"github.com/bhmj/pg-api/internal/pkg/breaker"
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/httpcache"
+	"github.com/bhmj/pg-api/internal/pkg/str"
+)
+
+const (
+	defaultRetryBackoff    = 100  // milliseconds
+	defaultRetryMaxBackoff = 2000 // milliseconds
+	defaultBreakerCooldown = 30   // seconds
+	defaultCacheSize       = 1000 // responses per cache
+)
+
+var defaultRetryStatuses = []int{502, 503, 504}
+
+// errBudget is returned when no time is left for the call in the method enhancement budget
+var errBudget = errors.New("enhancement time budget exceeded")
+
+// statusError is returned when external service replies with non-200 status
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: status %d", e.url, e.code)
+}
+
+// callExternal calls external service with retries, skipping the call if the service breaker is open.
+// Responses are taken from the cache if it is enabled for the step.
+// Every attempt is limited by timeout and by deadline if it is set.
+func (s *service) callExternal(step *enhanceStep, timeout time.Duration, deadline time.Time) (response []byte, flds map[string]interface{}, err error) {
+	call, err := s.externalRequest(step.enh, step.source, step.headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.doExternal(step, call, timeout, deadline)
+}
+
+// doExternal makes prepared external service call
+func (s *service) doExternal(step *enhanceStep, call *externalCall, timeout time.Duration, deadline time.Time) (response []byte, flds map[string]interface{}, err error) {
+	enh := step.enh
+	flds = call.flds
+
+	// cache
+	var cache *httpcache.Cache
+	var key, etag string
+	var cached *httpcache.Entry
+	if enh.Cache.TTL > 0 {
+		cache = s.cache(enh.Method+" "+step.cbName, enh.Cache)
+		key = cacheKey(call)
+		var fresh bool
+		if cached, fresh = cache.Get(key); fresh {
+			s.metrics.ExternalCache(s.vpath, step.metricName, "hit")
+			return cachedResponse(cached, enh.URL, flds)
+		}
+		if cached != nil {
+			etag = cached.ETag
+		}
+	}
+
+	if !deadline.IsZero() && time.Until(deadline) <= 0 {
+		return nil, nil, fmt.Errorf("%s: %w", step.cbName, errBudget)
+	}
+	var cb *breaker.Breaker
+	if enh.Breaker.Failures > 0 {
+		cb = s.breaker(step.cbName, enh.Breaker)
+		if !cb.Allow() {
+			return nil, nil, fmt.Errorf("%s: %w", step.cbName, breaker.ErrOpen)
+		}
+	}
+	var header http.Header
+	base := time.Duration(str.Icoalesce(enh.Retry.Backoff, defaultRetryBackoff)) * time.Millisecond
+	maxBackoff := time.Duration(str.Icoalesce(enh.Retry.MaxBackoff, defaultRetryMaxBackoff)) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		attemptTimeout := timeout
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				// the budget is spent: zero attempt timeout would mean no timeout at all
+				err = fmt.Errorf("%s: %w", step.cbName, errBudget)
+				break
+			}
+			if left < attemptTimeout {
+				attemptTimeout = left
+			}
+		}
+		response, header, err = s.queryExternal(enh, call, attemptTimeout, etag)
+		if err == nil || attempt > enh.Retry.Count || !retryable(err, enh.Retry.Statuses) {
+			break
+		}
+		delay := backoff.Delay(attempt, base, maxBackoff)
+		if !deadline.IsZero() && time.Until(deadline) <= delay {
+			break // no time left for the next attempt
+		}
+		s.log.L().Warnf("queryExternal: %s, retry %d of %d", err.Error(), attempt, enh.Retry.Count)
+		time.Sleep(delay)
+	}
+	status := statusCode(err)
+	if cb != nil {
+		if serviceDown(err) {
+			cb.Failure()
+		} else {
+			cb.Success() // the service is up
+		}
+	}
+
+	if cache != nil {
+		switch {
+		case status == http.StatusNotModified && cached != nil:
+			// revalidated
+			s.metrics.ExternalCache(s.vpath, step.metricName, "hit")
+			if ttl, store := httpcache.TTL(header, time.Duration(enh.Cache.TTL)*time.Second); store {
+				cache.Put(key, *cached, ttl)
+			}
+			return cachedResponse(cached, enh.URL, flds)
+		case err == nil:
+			s.metrics.ExternalCache(s.vpath, step.metricName, "miss")
+			if ttl, store := httpcache.TTL(header, time.Duration(enh.Cache.TTL)*time.Second); store {
+				cache.Put(key, httpcache.Entry{Status: http.StatusOK, Body: response, ETag: header.Get("ETag")}, ttl)
+			}
+		case status == http.StatusNotFound:
+			s.metrics.ExternalCache(s.vpath, step.metricName, "miss")
+			cache.Put(key, httpcache.Entry{Status: http.StatusNotFound}, time.Duration(enh.Cache.NotFoundTTL)*time.Second)
+		}
+	}
+	return
+}
+
+// cacheKey returns cache key of the call: headers are a part of the key as the response may depend on them
+func cacheKey(call *externalCall) string {
+	if len(call.header) == 0 {
+		return httpcache.Key(call.method, call.url, call.body)
+	}
+	header, _ := json.Marshal(call.header)
+	return httpcache.Key(call.method, call.url, append(append(header, '\n'), call.body...))
+}
+
+// cachedResponse returns cached response as if it was received from the service
+func cachedResponse(e *httpcache.Entry, url string, flds map[string]interface{}) ([]byte, map[string]interface{}, error) {
+	if e.Status != http.StatusOK {
+		return nil, flds, &statusError{url: url, code: e.Status}
+	}
+	return e.Body, flds, nil
+}
+
+// statusCode returns HTTP status of external call error or 0
+func statusCode(err error) int {
+	if se, ok := err.(*statusError); ok {
+		return se.code
+	}
+	return 0
+}
+
+// retryable reports whether the call may be repeated: network errors and listed statuses are retried
+func retryable(err error, statuses []int) bool {
+	se, ok := err.(*statusError)
+	if !ok {
+		return true
+	}
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, status := range statuses {
+		if se.code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceDown reports whether the call error counts as a breaker failure:
+// network errors, 5xx and 429 statuses do, other replies prove the service is up
+func serviceDown(err error) bool {
+	if err == nil {
+		return false
+	}
+	status := statusCode(err)
+	return status == 0 || status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+}
+
+// failureReason classifies external call error for metrics
+func failureReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, breaker.ErrOpen):
+		return "breaker"
+	case errors.Is(err, errBudget):
+		return "budget"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "error"
+}
+
+// breaker returns circuit breaker of the service, creating it on the first call.
+// Steps calling the same service share its breaker, so their Breaker settings are the same (see config validation).
+func (s *service) breaker(service string, cfg config.Breaker) *breaker.Breaker {
+	if cb, found := s.breakers.Load(service); found {
+		return cb.(*breaker.Breaker)
+	}
+	cooldown := time.Duration(str.Icoalesce(cfg.Cooldown, defaultBreakerCooldown)) * time.Second
+	cb, loaded := s.breakers.LoadOrStore(service, breaker.New(cfg.Failures, cooldown, func(state breaker.State) {
+		s.log.L().Warnf("external service %s: circuit breaker is %s", service, state)
+		s.metrics.BreakerState(service, int(state))
+	}))
+	if !loaded {
+		s.metrics.BreakerState(service, int(breaker.Closed))
+	}
+	return cb.(*breaker.Breaker)
+}
+
+// cache returns response cache of the service, creating it on the first call.
+// Steps share the cache if they call the same service with the same method and cache size.
+func (s *service) cache(service string, cfg config.EnhanceCache) *httpcache.Cache {
+	size := str.Icoalesce(cfg.Size, defaultCacheSize)
+	name := service + " " + strconv.Itoa(size)
+	if c, found := s.caches.Load(name); found {
+		return c.(*httpcache.Cache)
+	}
+	c, _ := s.caches.LoadOrStore(name, httpcache.New(size))
+	return c.(*httpcache.Cache)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// violation describes a single JSON Schema violation
+type violation struct {
+	Path    string `json:"path"`    // JSON pointer to the invalid value
+	Keyword string `json:"keyword"` // schema keyword location
+	Message string `json:"message"`
+}
+
+// validateJSON validates JSON document against the schema.
+// Returns apiError with a list of violations if the document is invalid.
+func validateJSON(schema *jsonschema.Schema, doc []byte) error {
+	var v interface{}
+	var err error
+	if len(bytes.TrimSpace(doc)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(doc))
+		dec.UseNumber()
+		if err = dec.Decode(&v); err != nil {
+			return &apiError{Message: "invalid JSON: " + err.Error()}
+		}
+	}
+	err = schema.Validate(v)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return &apiError{Message: err.Error()}
+	}
+	return &apiError{Message: "validation failed", Details: collectViolations(ve, nil)}
+}
+
+// collectViolations flattens validation error tree into a list of leaf errors
+func collectViolations(ve *jsonschema.ValidationError, list []violation) []violation {
+	if len(ve.Causes) == 0 {
+		return append(list, violation{Path: ve.InstanceLocation, Keyword: ve.KeywordLocation, Message: ve.Message})
+	}
+	for _, cause := range ve.Causes {
+		list = collectViolations(cause, list)
+	}
+	return list
+}
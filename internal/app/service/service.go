@@ -3,23 +3,31 @@ package service
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/bhmj/pg-api/internal/pkg/catalog"
 	"github.com/bhmj/pg-api/internal/pkg/config"
 	"github.com/bhmj/pg-api/internal/pkg/db"
 	"github.com/bhmj/pg-api/internal/pkg/files"
+	"github.com/bhmj/pg-api/internal/pkg/jobs"
+	"github.com/bhmj/pg-api/internal/pkg/lifecycle"
 	"github.com/bhmj/pg-api/internal/pkg/log"
 	"github.com/bhmj/pg-api/internal/pkg/metrics"
+	"github.com/bhmj/pg-api/internal/pkg/str"
 )
 
-// Readiness implements ready/notReady signal receiver
+// Readiness collects dependency checks which service readiness depends on
 type Readiness interface {
-	Ready()
-	NotReady()
+	AddCheck(name string, check lifecycle.Check)
 }
 
 type service struct {
@@ -29,9 +37,19 @@ type service struct {
 	readiness Readiness
 	metrics   metrics.Metrics
 	f         files.FileService
+	catalog   *catalog.Catalog
+	openapi   openAPICache // OpenAPI document built from config and catalog
 	// DB connection
-	dbr *sql.DB
-	dbw *sql.DB
+	dbr     *db.Pool
+	dbw     *sql.DB
+	db      *dbGroup        // default connections
+	tenants *tenantRegistry // tenant connections
+	shards  []*dbGroup      // shard connections
+	jobs    backgroundJobs  // finalize and postproc goroutines
+	queue   *jobs.Queue     // durable job queue (optional)
+	// external services
+	breakers sync.Map // circuit breakers by service host/path
+	caches   sync.Map // response caches by service method, host and path
 	// runtime params
 	version int    // API version
 	method  string // HTTP method
@@ -44,40 +62,196 @@ type service struct {
 type Service interface {
 	MainHandler(w http.ResponseWriter, r *http.Request)
 	FileHandler(w http.ResponseWriter, r *http.Request)
+	OpenAPIHandler(w http.ResponseWriter, r *http.Request)
+	JobHandler(w http.ResponseWriter, r *http.Request)
+	Drain(ctx context.Context)
 }
 
 // NewService returns new service
 func NewService(ctx context.Context, cfg *config.Config, log log.Logger, rd Readiness) (Service, error) {
+	var err error
 	srv := &service{
 		ctx:       ctx,
 		cfg:       cfg,
 		log:       log,
 		readiness: rd,
 		metrics:   metrics.NewMetrics(cfg.Service.Name, cfg.Service.Prometheus.Buckets),
+		catalog:   catalog.New(),
 	}
 	// prepare database connections
-	dbr, err := db.SetupDatabase(cfg.DBGroup.Read)
+	dbWriteSettings, same := cfg.GetDBWrite()
+	cfg.DBGroup.Write = dbWriteSettings
+	srv.dbw, err = db.SetupDatabase(dbWriteSettings)
 	if err != nil {
 		return nil, err
 	}
-	srv.dbr = dbr
-	dbWriteSettings, same := cfg.GetDBWrite()
-	cfg.DBGroup.Write = dbWriteSettings
+	replicas := make(map[string]*sql.DB)
+	for i, settings := range cfg.GetDBReplicas() {
+		replicas[fmt.Sprintf("#%d %s", i, settings.Host)], err = db.SetupDatabase(settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+	readDB := srv.dbw // reads fall back to primary if no replica is healthy
 	if !same {
-		srv.dbw, err = db.SetupDatabase(dbWriteSettings)
+		conn, err := db.SetupDatabase(cfg.DBGroup.Read)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		srv.dbw = srv.dbr
+		if len(replicas) == 0 {
+			readDB = conn
+		} else {
+			replicas["read "+cfg.DBGroup.Read.Host] = conn // read database is one of the replicas
+		}
+	}
+	maxLag := time.Duration(cfg.DBGroup.Balance.MaxLag * float64(time.Second))
+	srv.dbr = db.NewPool(readDB, replicas, cfg.DBGroup.Balance.Strategy, maxLag, log)
+	go srv.dbr.Run(ctx, time.Duration(str.Icoalesce(cfg.DBGroup.Balance.CheckPeriod, defaultReplicaCheckPeriod))*time.Second)
+	srv.db = &dbGroup{
+		dbr:         srv.dbr,
+		dbw:         srv.dbw,
+		readSchema:  cfg.DBGroup.Read.Schema,
+		writeSchema: cfg.DBGroup.Write.Schema,
+		readSource:  catalogRead,
+		writeSource: catalogRead,
+	}
+	if !same {
+		srv.db.writeSource = catalogWrite
 	}
+	srv.tenants = newTenantRegistry(cfg.Tenants.List)
+	if err = srv.openShards(); err != nil {
+		return nil, err
+	}
+	srv.metrics.WatchPools(srv.poolStats)
+
 	if cfg.Minio.Host != "" {
 		srv.f, err = files.NewFileService(&cfg.Minio, srv.dbw, log, cfg.HTTP.Endpoint, cfg.General.HeadersPass)
 	}
+	// function catalog
+	go srv.refreshCatalog()
+	// durable job queue
+	if cfg.Jobs.Enable {
+		srv.queue = jobs.New(srv.dbw, cfg.Jobs, log)
+		srv.queue.Register(jobFinalize, srv.runFinalizeJob)
+		srv.queue.Register(jobCallback, srv.runCallbackJob)
+		srv.queue.Register(jobPostproc, srv.runPostprocJob)
+		srv.queue.OnFinish(srv.finishJob)
+		go srv.queue.Run(ctx)
+	}
+	srv.addChecks()
 
 	return srv, err
 }
 
+// addChecks adds dependency checks for readiness
+func (s *service) addChecks() {
+	s.readiness.AddCheck("db-write", func(ctx context.Context) error {
+		return s.dbw.PingContext(ctx)
+	})
+	if s.dbr.Primary() != s.dbw || len(s.dbr.Replicas()) > 0 {
+		s.readiness.AddCheck("db-read", func(ctx context.Context) error {
+			return s.dbr.DB().PingContext(ctx)
+		})
+	}
+	if s.f != nil {
+		s.readiness.AddCheck("minio", func(ctx context.Context) error {
+			return s.f.Ping(ctx)
+		})
+	}
+	s.readiness.AddCheck("catalog", func(ctx context.Context) error {
+		if !s.catalog.Loaded(s.db.readSource, s.db.readSchema) {
+			return fmt.Errorf("schema %s is not loaded", s.db.readSchema)
+		}
+		if !s.catalog.Loaded(s.db.writeSource, s.db.writeSchema) {
+			return fmt.Errorf("schema %s is not loaded", s.db.writeSchema)
+		}
+		return nil
+	})
+}
+
+// poolStats returns statistics of all open connection pools by name
+func (s *service) poolStats() map[string]*pgxpool.Stat {
+	stats := make(map[string]*pgxpool.Stat)
+	seen := make(map[*sql.DB]bool)
+	add := func(name string, conn *sql.DB) {
+		if !seen[conn] {
+			seen[conn] = true
+			if st, found := db.Stat(conn); found {
+				stats[name] = st
+			}
+		}
+	}
+	add("write", s.dbw)
+	add("read", s.dbr.Primary())
+	for name, conn := range s.dbr.Replicas() {
+		add("replica "+name, conn)
+	}
+	s.tenants.mx.Lock()
+	for _, grp := range s.tenants.groups {
+		if grp.own {
+			add("tenant "+grp.tenant+" write", grp.dbw)
+			add("tenant "+grp.tenant+" read", grp.dbr.Primary())
+		}
+	}
+	s.tenants.mx.Unlock()
+	for _, sh := range s.shards {
+		add("shard "+sh.shard+" write", sh.dbw)
+		add("shard "+sh.shard+" read", sh.dbr.Primary())
+	}
+	return stats
+}
+
+// loadCatalog reads function lists of read and write schemas.
+// Write schema is loaded separately if it is in another database or has another name.
+func (s *service) loadCatalog() {
+	s.loadSchema(s.dbr.DB(), s.db.readSource, s.db.readSchema)
+	if s.db.writeSource != s.db.readSource || s.db.writeSchema != s.db.readSchema {
+		s.loadSchema(s.dbw, s.db.writeSource, s.db.writeSchema)
+	}
+	for _, grp := range s.openTenants() {
+		s.loadTenantCatalog(grp, true)
+		s.releaseGroup(grp)
+	}
+	// shards are expected to have the same functions, so a shard schema is loaded only if it has other name
+	for _, sh := range s.shards {
+		for _, schema := range []string{sh.readSchema, sh.writeSchema} {
+			if schema != s.cfg.DBGroup.Read.Schema && schema != s.cfg.DBGroup.Write.Schema {
+				s.loadSchema(sh.dbw, sh.writeSource, schema)
+			}
+		}
+	}
+}
+
+// loadSchema reads function list of the schema from the source database
+func (s *service) loadSchema(conn *sql.DB, source string, schema string) {
+	if err := s.catalog.Load(conn, source, schema); err != nil {
+		s.log.L().Errorf("catalog load (%s %s): %s", source, schema, err.Error())
+	}
+}
+
+// refreshCatalog loads function catalog and then periodically reloads it.
+// If no refresh period is set the catalog is reloaded only until the first successful load.
+func (s *service) refreshCatalog() {
+	s.loadCatalog()
+	period := time.Duration(s.cfg.Catalog.Refresh) * time.Second
+	if period == 0 {
+		period = catalogRetryPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if s.cfg.Catalog.Refresh == 0 && s.catalog.Loaded(s.db.readSource, s.db.readSchema) && s.catalog.Loaded(s.db.writeSource, s.db.writeSchema) {
+				return
+			}
+			s.loadCatalog()
+		}
+	}
+}
+
 // prepareParams prepares parameters for query processing
 func (s *service) prepare(w http.ResponseWriter, r *http.Request, needVersion bool) (err error) {
 
@@ -95,17 +269,24 @@ func (s *service) prepare(w http.ResponseWriter, r *http.Request, needVersion bo
 	// API version & path
 	path := r.URL.Path[len(s.cfg.HTTP.Endpoint)+2:]
 	if needVersion {
+		s.path = path
 		subs := regexpMap["version"].FindStringSubmatch(path)
-		if subs == nil {
-			http.Error(w, "API version not specified", http.StatusBadRequest)
-			return
+		if subs != nil {
+			s.version, _ = strconv.Atoi(subs[1])
+			s.path = path[len(subs[0]):]
+		} else {
+			// version negotiation: Accept-Version or media type parameter
+			s.version, err = negotiateVersion(r.Header)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 		}
-		s.version, _ = strconv.Atoi(subs[1])
 		if s.version == 0 {
-			http.Error(w, "invalid API version", http.StatusBadRequest)
+			err = errors.New("invalid API version")
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		s.path = path[len(subs[0]):]
 	} else {
 		s.path = path
 	}
@@ -124,6 +305,33 @@ func (s *service) prepare(w http.ResponseWriter, r *http.Request, needVersion bo
 	return
 }
 
+// negotiateVersion returns API version requested via headers
+// ("Accept-Version: 3" or "Accept: application/json; version=3")
+func negotiateVersion(h http.Header) (int, error) {
+	if v := strings.TrimSpace(h.Get("Accept-Version")); v != "" {
+		return parseVersion(v)
+	}
+	for _, accept := range h.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			for _, param := range strings.Split(mediaType, ";")[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && (strings.EqualFold(kv[0], "version") || strings.EqualFold(kv[0], "v")) {
+					return parseVersion(strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+	}
+	return 0, errors.New("API version not specified")
+}
+
+func parseVersion(v string) (int, error) {
+	ver, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(v), "v"))
+	if err != nil || ver <= 0 {
+		return 0, errors.New("invalid API version")
+	}
+	return ver, nil
+}
+
 // MainHandler implements service logic
 func (s *service) MainHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
@@ -140,7 +348,7 @@ func (s *service) MainHandler(w http.ResponseWriter, r *http.Request) {
 	// process
 	code, err := s.processQuery(w, r)
 	if err != nil {
-		http.Error(w, err.Error(), code)
+		s.writeError(w, code, err)
 		return
 	}
 
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/bhmj/pg-api/internal/pkg/catalog"
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/log"
+	"github.com/bhmj/pg-api/internal/pkg/metrics"
+)
+
+// metrics are registered globally, so all test services share them
+var testMetrics = metrics.NewMetrics("pgapi_test", nil)
+
+// newTestService returns a service without database connections
+func newTestService(cfg *config.Config) *service {
+	logger, _ := log.New(0)
+	return &service{
+		ctx:     context.Background(),
+		cfg:     cfg,
+		db:      &dbGroup{},
+		log:     logger,
+		metrics: testMetrics,
+		catalog: catalog.New(),
+	}
+}
+
+// rowsDriver is a database driver replying to queries with rows returned by reply function.
+// Data source name tells which database is queried.
+type rowsDriver struct {
+	mx    sync.Mutex
+	query func(dsn string, query string, args []driver.Value) (columns []string, rows [][]driver.Value)
+}
+
+func (d *rowsDriver) Open(dsn string) (driver.Conn, error) { return &rowsConn{d, dsn}, nil }
+
+// reply sets query function
+func (d *rowsDriver) reply(fn func(dsn string, query string, args []driver.Value) ([]string, [][]driver.Value)) {
+	d.mx.Lock()
+	d.query = fn
+	d.mx.Unlock()
+}
+
+type rowsConn struct {
+	d   *rowsDriver
+	dsn string
+}
+
+func (c *rowsConn) Prepare(query string) (driver.Stmt, error) { return &rowsStmt{c, query}, nil }
+func (c *rowsConn) Close() error                              { return nil }
+func (c *rowsConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type rowsStmt struct {
+	c     *rowsConn
+	query string
+}
+
+func (s *rowsStmt) Close() error  { return nil }
+func (s *rowsStmt) NumInput() int { return -1 }
+func (s *rowsStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s *rowsStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.c.d.mx.Lock()
+	query := s.c.d.query
+	s.c.d.mx.Unlock()
+	columns, rows := query(s.c.dsn, s.query, args)
+	return &rowsResult{columns: columns, rows: rows}, nil
+}
+
+type rowsResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rowsResult) Columns() []string { return r.columns }
+func (r *rowsResult) Close() error      { return nil }
+func (r *rowsResult) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var testRowsDriver = &rowsDriver{}
+
+func init() {
+	sql.Register("pgapi_rows", testRowsDriver)
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bhmj/pg-api/internal/pkg/db"
+	phttp "github.com/bhmj/pg-api/internal/pkg/http"
+	"github.com/bhmj/pg-api/internal/pkg/shard"
+)
+
+var errShardKey = errors.New("invalid shard key")
+
+// openShards sets up shard connections
+func (s *service) openShards() error {
+	for i := range s.cfg.Shards.List {
+		sh := &s.cfg.Shards.List[i]
+		readSettings, writeSettings := s.cfg.GetShardDB(sh)
+		dbw, err := db.SetupDatabase(writeSettings)
+		if err != nil {
+			return fmt.Errorf("shard %s: %w", sh.Name, err)
+		}
+		dbr := dbw
+		if readSettings != writeSettings {
+			if dbr, err = db.SetupDatabase(readSettings); err != nil {
+				return fmt.Errorf("shard %s: %w", sh.Name, err)
+			}
+		}
+		s.shards = append(s.shards, &dbGroup{
+			shard:       sh.Name,
+			dbr:         db.NewPool(dbr, nil, "", 0, s.log),
+			dbw:         dbw,
+			readSchema:  readSettings.Schema,
+			writeSchema: writeSettings.Schema,
+			readSource:  s.db.readSource,
+			writeSource: s.db.writeSource,
+		})
+	}
+	return nil
+}
+
+// shardGroup returns shard connections for the request.
+// Requests without shard key and requests served by tenant's own database are not sharded.
+// Schema-only tenants keep their schemas on the shard.
+func (s *service) shardGroup(r *http.Request, parsed ParsedURL, body []byte, grp *dbGroup) (*dbGroup, error) {
+	if len(s.shards) == 0 || grp.own {
+		return grp, nil
+	}
+	key := s.shardKey(r, parsed, body)
+	if key == "" {
+		return grp, nil
+	}
+	i, err := shard.Select(key, s.cfg.Shards)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errShardKey, err.Error())
+	}
+	return tenantShard(s.shards[i], grp), nil
+}
+
+// tenantShard returns shard connections with schemas of a schema-only tenant
+func tenantShard(sh *dbGroup, grp *dbGroup) *dbGroup {
+	if grp.tenant == "" {
+		return sh
+	}
+	g := *sh
+	g.tenant, g.readSchema, g.writeSchema = grp.tenant, grp.readSchema, grp.writeSchema
+	return &g
+}
+
+// shardKey returns shard key from URL path, header or body field (in this order)
+func (s *service) shardKey(r *http.Request, parsed ParsedURL, body []byte) string {
+	key := s.cfg.Shards.Key
+	if key.Path != "" {
+		for i, seg := range strings.Split(strings.Trim(parsed.MethodPath, "/"), "/") {
+			if seg == key.Path && i < len(parsed.ID) && parsed.ID[i] != 0 {
+				return strconv.FormatInt(parsed.ID[i], 10)
+			}
+		}
+	}
+	if key.Header != "" {
+		if v := r.Header.Get(key.Header); v != "" {
+			return v
+		}
+	}
+	if key.Field != "" {
+		var obj map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if dec.Decode(&obj) == nil {
+			switch v := obj[key.Field].(type) {
+			case string:
+				return v
+			case json.Number:
+				return v.String()
+			}
+		}
+	}
+	return ""
+}
+
+// fanOut calls the read function on every shard in parallel and combines the results.
+// If any shard function returns an error, its result is returned as is.
+func (s *service) fanOut(parsed ParsedURL, grp *dbGroup, body []byte, headers []phttp.HeaderValue) (result string, query string, err error) {
+	n := len(s.shards)
+	results := make([]string, n)
+	queries := make([]string, n)
+	args := make([][]interface{}, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range s.shards {
+		sh := tenantShard(s.shards[i], grp)
+		conn := sh.dbr.DB()
+		queries[i], args[i] = s.prepareSQL(sh.readSource, sh.readSchema, parsed, string(body), headers, 0)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.makeDBRequest(conn, queries[i], args[i], &results[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range s.shards {
+		if errs[i] != nil {
+			return "", queries[i], fmt.Errorf("shard %s: %w", s.shards[i].shard, errs[i])
+		}
+		var qRes queryResult
+		if json.Unmarshal([]byte(results[i]), &qRes) == nil && qRes.Error != "" {
+			return results[i], queries[i], nil
+		}
+	}
+
+	query = queries[0]
+	if parsed.FanOut == "merge" {
+		result, err = shard.Merge(results)
+	} else {
+		result, err = shard.Concat(results)
+	}
+	return
+}
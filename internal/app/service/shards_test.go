@@ -0,0 +1,111 @@
+package service
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// newShardService returns a service with shards s1 (keys below 100) and s2 (keys from 100)
+func newShardService(t *testing.T, reply func(shard string, query string) string) *service {
+	testRowsDriver.reply(func(dsn string, query string, args []driver.Value) ([]string, [][]driver.Value) {
+		return []string{"result"}, [][]driver.Value{{reply(dsn, query)}}
+	})
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = "api"
+	cfg.General.Convention = "CRUD"
+	cfg.Shards.Method = "range"
+	cfg.Shards.Key = config.ShardKey{Path: "customer", Header: "X-Customer", Field: "customer_id"}
+	cfg.Shards.List = []config.Shard{{Name: "s1", From: 0, To: 100}, {Name: "s2", From: 100}}
+	s := newTestService(cfg)
+	s.db = &dbGroup{readSchema: "api", writeSchema: "api", readSource: catalogRead, writeSource: catalogRead}
+	for _, sh := range cfg.Shards.List {
+		conn, _ := sql.Open("pgapi_rows", sh.Name)
+		t.Cleanup(func() { conn.Close() })
+		s.shards = append(s.shards, &dbGroup{
+			shard: sh.Name, dbr: db.NewPool(conn, nil, "", 0, s.log), dbw: conn,
+			readSchema: "api", writeSchema: "api", readSource: catalogRead, writeSource: catalogRead,
+		})
+	}
+	return s
+}
+
+func Test_ShardSelection(t *testing.T) {
+	s := newShardService(t, func(shard string, query string) string {
+		return `{"shard":"` + shard + `"}`
+	})
+	call := func(method string, path string, body string, header string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if header != "" {
+			r.Header.Set("X-Customer", header)
+		}
+		w := httptest.NewRecorder()
+		s.MainHandler(w, r)
+		return w
+	}
+
+	for _, tst := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header string
+		shard  string
+	}{
+		{"path", "GET", "/api/v1/customer/5/orders/1", "", "", "s1"},
+		{"path", "GET", "/api/v1/customer/150/orders/1", "", "", "s2"},
+		{"path wins", "GET", "/api/v1/customer/5/orders/1", "", "150", "s1"},
+		{"header", "GET", "/api/v1/orders/1", "", "150", "s2"},
+		{"body field", "POST", "/api/v1/orders", `{"customer_id":150}`, "", "s2"},
+		{"body string field", "POST", "/api/v1/orders", `{"customer_id":"5"}`, "", "s1"},
+	} {
+		w := call(tst.method, tst.path, tst.body, tst.header)
+		assert.JSONEq(t, `{"shard":"`+tst.shard+`"}`, replyJSON(w), tst.name)
+	}
+
+	// a key out of the shard ranges
+	w := call("GET", "/api/v1/orders/1", "", "-1")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_ShardFanOut(t *testing.T) {
+	s := newShardService(t, func(shard string, query string) string {
+		switch {
+		case strings.Contains(query, "failing_get"):
+			if shard == "s2" {
+				return `{"error":"broken","httpcode":409}`
+			}
+			return `{"items":[]}`
+		case strings.Contains(query, "stats_get"):
+			return `{"total":{"` + shard + `":1},"items":["` + shard + `"],"shard":"` + shard + `"}`
+		}
+		return `[{"shard":"` + shard + `"}]`
+	})
+	call := func(method string, path string, fanOut string) *httptest.ResponseRecorder {
+		s.cfg.General.FanOut = fanOut
+		w := httptest.NewRecorder()
+		s.MainHandler(w, httptest.NewRequest(method, path, strings.NewReader(`{}`)))
+		return w
+	}
+
+	// arrays are concatenated in shard order
+	w := call("GET", "/api/v1/orders", "concat")
+	assert.JSONEq(t, `[{"shard":"s1"},{"shard":"s2"}]`, replyJSON(w))
+	// objects are merged, later shards win
+	w = call("GET", "/api/v1/stats", "merge")
+	assert.JSONEq(t, `{"total":{"s1":1,"s2":1},"items":["s1","s2"],"shard":"s2"}`, replyJSON(w))
+	// an error of any shard is returned as is
+	w = call("GET", "/api/v1/failing", "merge")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"broken","httpcode":409}`, replyJSON(w))
+	// writes are routed by the shard key
+	w = call("POST", "/api/v1/customer/150/orders", "concat")
+	assert.JSONEq(t, `[{"shard":"s2"}]`, replyJSON(w))
+}
@@ -6,8 +6,12 @@ import (
 
 // ParsedURL contains parsed data from query URL
 type ParsedURL struct {
+	Path       string // "/path/123/to/method/" (URL path without version)
 	MethodPath string // "/path/to/method/"
 	QueryPath  string // "path_to_method"
 	ID         []int64
+	Method     string // HTTP method (or HIT)
+	Version    int    // API version
+	UserID     int64  // authenticated user ID if any
 	config.MethodConfig
 }
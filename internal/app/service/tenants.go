@@ -0,0 +1,259 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/auth"
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/db"
+)
+
+var errUnknownTenant = errors.New("unknown tenant")
+
+// dbGroup is a set of database connections and schemas serving a request
+type dbGroup struct {
+	tenant      string // tenant name, empty for default group
+	shard       string // shard name
+	dbr         *db.Pool
+	dbw         *sql.DB
+	readSchema  string
+	writeSchema string
+	readSource  string    // function catalog database for reads
+	writeSource string    // function catalog database for writes
+	own         bool      // tenant has its own connections
+	lastUsed    time.Time // for closing least recently used pools
+	refs        int       // requests and jobs using own connections
+	evicted     bool      // own connections are closed after the last release
+}
+
+// tenantRegistry keeps lazily opened tenant connections
+type tenantRegistry struct {
+	mx     sync.Mutex
+	match  map[string]*config.Tenant // match value -> tenant
+	groups map[string]*dbGroup       // tenant name -> connections
+}
+
+func newTenantRegistry(list []config.Tenant) *tenantRegistry {
+	t := &tenantRegistry{
+		match:  make(map[string]*config.Tenant),
+		groups: make(map[string]*dbGroup),
+	}
+	for i := range list {
+		for _, m := range list[i].Match {
+			t.match[strings.ToLower(m)] = &list[i]
+		}
+	}
+	return t
+}
+
+// dbGroup returns database connections for the request: default ones or tenant's.
+// The group must be released with releaseGroup when the request is done.
+func (s *service) dbGroup(r *http.Request) (*dbGroup, error) {
+	if len(s.cfg.Tenants.List) == 0 {
+		return s.db, nil
+	}
+	tenant, found := s.tenants.match[strings.ToLower(s.tenantKey(r))]
+	if !found {
+		return nil, errUnknownTenant
+	}
+	return s.openTenant(tenant)
+}
+
+// tenantKey returns tenant identifier from the request according to Tenants.Source
+func (s *service) tenantKey(r *http.Request) string {
+	switch s.cfg.Tenants.Source {
+	case "header":
+		return r.Header.Get(s.cfg.Tenants.Header)
+	case "caller":
+		return auth.GetCaller(r.Context())
+	default:
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			return r.Host
+		}
+		return host
+	}
+}
+
+// openTenant returns tenant connections opening them if necessary.
+// The group is acquired by the caller and must be released with releaseGroup.
+func (s *service) openTenant(tenant *config.Tenant) (*dbGroup, error) {
+	reg := s.tenants
+	reg.mx.Lock()
+	if grp, found := reg.groups[tenant.Name]; found {
+		grp.lastUsed = time.Now()
+		grp.refs++
+		reg.mx.Unlock()
+		return grp, nil
+	}
+	reg.mx.Unlock()
+
+	// own pools are opened outside of the registry lock not to block requests of other tenants
+	readSettings, writeSettings := s.cfg.GetTenantDB(tenant)
+	grp := &dbGroup{
+		tenant:      tenant.Name,
+		dbr:         s.dbr,
+		dbw:         s.dbw,
+		readSchema:  readSettings.Schema,
+		writeSchema: writeSettings.Schema,
+		readSource:  s.db.readSource,
+		writeSource: s.db.writeSource,
+		own:         tenant.OwnDatabase(),
+	}
+	if grp.own {
+		dbw, err := db.SetupDatabase(writeSettings)
+		if err != nil {
+			return nil, err
+		}
+		dbr := dbw
+		if readSettings != writeSettings {
+			if dbr, err = db.SetupDatabase(readSettings); err != nil {
+				db.Close(dbw)
+				return nil, err
+			}
+		}
+		grp.dbw = dbw
+		grp.dbr = db.NewPool(dbr, nil, "", 0, s.log)
+		// functions of tenant database are loaded on every pool opening
+		grp.readSource = "tenant " + tenant.Name + " read"
+		grp.writeSource = grp.readSource
+		if readSettings != writeSettings {
+			grp.writeSource = "tenant " + tenant.Name + " write"
+		}
+	}
+
+	reg.mx.Lock()
+	if opened, found := reg.groups[tenant.Name]; found {
+		// opened by a concurrent request
+		opened.lastUsed = time.Now()
+		opened.refs++
+		reg.mx.Unlock()
+		if grp.own {
+			closePools(grp)
+		}
+		return opened, nil
+	}
+	var evicted []*dbGroup
+	if grp.own && s.cfg.Tenants.MaxPools > 0 {
+		evicted = s.evictTenants(s.cfg.Tenants.MaxPools - 1)
+	}
+	grp.lastUsed = time.Now()
+	grp.refs = 2 // the caller and the catalog loader
+	reg.groups[tenant.Name] = grp
+	reg.mx.Unlock()
+
+	for _, idle := range evicted {
+		s.closeTenant(idle)
+	}
+	if grp.own {
+		s.log.L().Infof("tenant %s: connection pool opened", tenant.Name)
+	}
+
+	// function catalog for tenant schemas
+	go func() {
+		defer s.releaseGroup(grp)
+		s.loadTenantCatalog(grp, grp.own)
+	}()
+
+	return grp, nil
+}
+
+// loadTenantCatalog reads function lists of tenant schemas: all of them if reload is set, only missing ones otherwise
+func (s *service) loadTenantCatalog(grp *dbGroup, reload bool) {
+	if reload || !s.catalog.Loaded(grp.readSource, grp.readSchema) {
+		s.loadSchema(grp.dbr.DB(), grp.readSource, grp.readSchema)
+	}
+	if grp.writeSource != grp.readSource || grp.writeSchema != grp.readSchema {
+		if reload || !s.catalog.Loaded(grp.writeSource, grp.writeSchema) {
+			s.loadSchema(grp.dbw, grp.writeSource, grp.writeSchema)
+		}
+	}
+}
+
+// openTenants returns acquired groups of open tenant connections
+func (s *service) openTenants() []*dbGroup {
+	reg := s.tenants
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+	groups := make([]*dbGroup, 0, len(reg.groups))
+	for _, grp := range reg.groups {
+		grp.refs++
+		groups = append(groups, grp)
+	}
+	return groups
+}
+
+// acquireGroup marks connections as used by one more request or job
+func (s *service) acquireGroup(grp *dbGroup) {
+	if !grp.own {
+		return
+	}
+	s.tenants.mx.Lock()
+	grp.refs++
+	s.tenants.mx.Unlock()
+}
+
+// releaseGroup marks connections as no longer used by a request or job.
+// Evicted tenant connections are closed after the last release.
+func (s *service) releaseGroup(grp *dbGroup) {
+	if !grp.own {
+		return
+	}
+	s.tenants.mx.Lock()
+	grp.refs--
+	unused := grp.refs == 0 && grp.evicted
+	s.tenants.mx.Unlock()
+	if unused {
+		s.closeTenant(grp)
+	}
+}
+
+// evictTenants removes least recently used tenant pools from the registry leaving no more than max of them.
+// Idle pools are evicted first and returned to be closed by the caller outside of the registry lock.
+// Pools in use are closed when the last request or job releases them.
+// Must be called under registry lock.
+func (s *service) evictTenants(max int) (idle []*dbGroup) {
+	reg := s.tenants
+	for {
+		var lru *dbGroup
+		count := 0
+		for _, grp := range reg.groups {
+			if !grp.own {
+				continue
+			}
+			count++
+			if lru == nil || grp.refs == 0 && lru.refs > 0 ||
+				(grp.refs == 0) == (lru.refs == 0) && grp.lastUsed.Before(lru.lastUsed) {
+				lru = grp
+			}
+		}
+		if count <= max || lru == nil {
+			return
+		}
+		delete(reg.groups, lru.tenant)
+		lru.evicted = true
+		if lru.refs == 0 {
+			idle = append(idle, lru)
+		}
+	}
+}
+
+// closeTenant closes connections of an evicted tenant group which is no longer used
+func (s *service) closeTenant(grp *dbGroup) {
+	closePools(grp)
+	s.log.L().Infof("tenant %s: connection pool closed", grp.tenant)
+}
+
+// closePools closes tenant's own connections
+func closePools(grp *dbGroup) {
+	if grp.dbr.Primary() != grp.dbw {
+		db.Close(grp.dbr.Primary())
+	}
+	db.Close(grp.dbw)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTenantService returns a service with tenants a, b and c having their own databases
+// (pools are opened lazily, so nothing listens at their address)
+func newTenantService(maxPools int) *service {
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = "api"
+	cfg.Tenants.MaxPools = maxPools
+	for _, name := range []string{"a", "b", "c"} {
+		cfg.Tenants.List = append(cfg.Tenants.List, config.Tenant{
+			Name:  name,
+			Match: []string{name + ".example.com"},
+			Read:  config.Database{Host: "127.0.0.1", Port: 1, Name: name, Schema: "api"},
+		})
+	}
+	s := newTestService(cfg)
+	s.tenants = newTenantRegistry(cfg.Tenants.List)
+	return s
+}
+
+// openTestTenant opens tenant pool and waits for its catalog loader to finish
+func openTestTenant(t *testing.T, s *service, i int) *dbGroup {
+	grp, err := s.openTenant(&s.cfg.Tenants.List[i])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for refs := 0; refs != 1; {
+		s.tenants.mx.Lock()
+		refs = grp.refs
+		s.tenants.mx.Unlock()
+		runtime.Gosched()
+	}
+	return grp
+}
+
+// tenantNames returns names of tenants with open pools
+func tenantNames(s *service) []string {
+	s.tenants.mx.Lock()
+	defer s.tenants.mx.Unlock()
+	var names []string
+	for name := range s.tenants.groups {
+		names = append(names, name)
+	}
+	return names
+}
+
+func Test_TenantEviction(t *testing.T) {
+	s := newTenantService(2)
+
+	a := openTestTenant(t, s, 0)
+	s.releaseGroup(a)
+	b := openTestTenant(t, s, 1)
+	s.releaseGroup(b)
+	// the same pool is reused
+	again, _ := s.openTenant(&s.cfg.Tenants.List[0])
+	assert.Same(t, a, again)
+	s.releaseGroup(again)
+
+	// b is the least recently used one
+	c := openTestTenant(t, s, 2)
+	assert.ElementsMatch(t, []string{"a", "c"}, tenantNames(s))
+	assert.True(t, b.evicted)
+	assert.Equal(t, 0, b.refs)
+	assert.Equal(t, "sql: database is closed", errText(b.dbw.Ping()))
+
+	// a is in use: idle c is evicted instead
+	a, _ = s.openTenant(&s.cfg.Tenants.List[0])
+	s.releaseGroup(c)
+	b = openTestTenant(t, s, 1)
+	assert.ElementsMatch(t, []string{"a", "b"}, tenantNames(s))
+	assert.True(t, c.evicted)
+	s.releaseGroup(b)
+
+	// all pools are in use: a is evicted but is not closed until released
+	b, _ = s.openTenant(&s.cfg.Tenants.List[1])
+	c = openTestTenant(t, s, 2)
+	assert.ElementsMatch(t, []string{"b", "c"}, tenantNames(s))
+	assert.True(t, a.evicted)
+	assert.Equal(t, 1, a.refs)
+	assert.NotEqual(t, "sql: database is closed", errText(a.dbw.Ping()))
+	s.releaseGroup(a)
+	assert.Equal(t, "sql: database is closed", errText(a.dbw.Ping()))
+	s.releaseGroup(b)
+	s.releaseGroup(c)
+}
+
+func errText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func Test_UnknownTenant(t *testing.T) {
+	s := newTenantService(0)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/orders/1", nil)
+	r.Host = "d.example.com"
+	w := httptest.NewRecorder()
+	s.MainHandler(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Len(t, tenantNames(s), 0)
+}
@@ -41,7 +41,7 @@ func (s *service) wrappedGetUserID(r *http.Request) (uID int64, err error) {
 	items := strings.SplitN(val[s.cfg.Auth.Offset:], s.cfg.Auth.Separator, -1)
 	item := items[s.cfg.Auth.Part]
 
-	rows, err := s.dbr.Query("select * from "+s.cfg.Auth.Procedure+"($1)", item)
+	rows, err := s.dbr.DB().Query("select * from "+s.cfg.Auth.Procedure+"($1)", item)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,15 @@
+package backoff
+
+import "time"
+
+// Delay returns delay before the next attempt: base, 2*base, 4*base, ... up to max
+func Delay(attempt int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
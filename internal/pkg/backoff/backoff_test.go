@@ -0,0 +1,17 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Delay(t *testing.T) {
+	base, max := time.Second, 10*time.Second
+	assert.Equal(t, time.Second, Delay(1, base, max))
+	assert.Equal(t, 2*time.Second, Delay(2, base, max))
+	assert.Equal(t, 8*time.Second, Delay(4, base, max))
+	assert.Equal(t, max, Delay(5, base, max))
+	assert.Equal(t, max, Delay(100, base, max))
+}
@@ -0,0 +1,99 @@
+package bodytpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/bhmj/jsonslice"
+)
+
+// rxKey matches {$...} placeholder
+var rxKey = regexp.MustCompile(`{(\$.+?)}`)
+
+// Render returns a copy of the template with {$...} placeholders replaced by values from the source JSON.
+// A string consisting of a single placeholder is replaced by the value itself (of any type, null if not found; numbers are json.Number),
+// placeholders inside a string are replaced by the value text.
+func Render(tpl interface{}, source []byte) (interface{}, error) {
+	switch t := tpl.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			r, err := Render(v, source)
+			if err != nil {
+				return nil, err
+			}
+			res[k] = r
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(t))
+		for i, v := range t {
+			r, err := Render(v, source)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = r
+		}
+		return res, nil
+	case string:
+		if m := rxKey.FindStringSubmatch(t); m != nil && m[0] == t {
+			return value(m[1], source)
+		}
+		return Expand(t, source)
+	}
+	return tpl, nil
+}
+
+// Expand replaces {$...} placeholders in the string by value text from the source JSON
+func Expand(s string, source []byte) (string, error) {
+	return ExpandEscaped(s, source, nil)
+}
+
+// ExpandEscaped replaces {$...} placeholders in the string by value text from the source JSON
+// passed through escape function (if it is not nil)
+func ExpandEscaped(s string, source []byte, escape func(string) string) (string, error) {
+	var err error
+	res := rxKey.ReplaceAllStringFunc(s, func(key string) string {
+		v, e := value(key[1:len(key)-1], source)
+		if e != nil {
+			err = e
+			return ""
+		}
+		if escape != nil {
+			return escape(Text(v))
+		}
+		return Text(v)
+	})
+	return res, err
+}
+
+// Text returns text form of a value: strings as is, null as empty string, others as JSON
+func Text(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func value(path string, source []byte) (interface{}, error) {
+	v, err := jsonslice.Get(source, path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	var res interface{}
+	dec := json.NewDecoder(bytes.NewReader(v))
+	dec.UseNumber() // keep large integers intact
+	if err = dec.Decode(&res); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return res, nil
+}
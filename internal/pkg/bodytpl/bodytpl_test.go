@@ -0,0 +1,48 @@
+package bodytpl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var source = []byte(`{"id":12345678, "name":"Nike \"Air\"", "tags":["a","b"], "price":{"value":9.5}}`)
+
+func Test_Render(t *testing.T) {
+	var tpl interface{} = map[string]interface{}{
+		"order": map[string]interface{}{
+			"id":    "{$.id}",
+			"tags":  "{$.tags}",
+			"title": "{$.name} #{$.id}",
+			"none":  "{$.missing}",
+		},
+		"items": []interface{}{"{$.price.value}", 1.0, true},
+	}
+	res, err := Render(tpl, source)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"order": map[string]interface{}{
+			"id":    json.Number("12345678"),
+			"tags":  []interface{}{"a", "b"},
+			"title": `Nike "Air" #12345678`,
+			"none":  nil,
+		},
+		"items": []interface{}{json.Number("9.5"), 1.0, true},
+	}, res)
+	// template is not changed
+	assert.Equal(t, "{$.id}", tpl.(map[string]interface{})["order"].(map[string]interface{})["id"])
+}
+
+func Test_Expand(t *testing.T) {
+	s, err := Expand("Bearer {$.name}/{$.tags}/{$.price}", source)
+	assert.Nil(t, err)
+	assert.Equal(t, `Bearer Nike "Air"/["a","b"]/{"value":9.5}`, s)
+	s, err = Expand("static", source)
+	assert.Nil(t, err)
+	assert.Equal(t, "static", s)
+	s, err = ExpandEscaped("/api/{$.name}", source, strings.ToUpper)
+	assert.Nil(t, err)
+	assert.Equal(t, `/api/NIKE "AIR"`, s)
+}
@@ -0,0 +1,114 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned instead of calling a service whose breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is a breaker state
+type State int
+
+// Breaker states
+const (
+	Closed   State = iota // calls are allowed
+	HalfOpen              // one trial call is allowed
+	Open                  // calls are skipped
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "open"
+}
+
+// Breaker is a circuit breaker.
+// It opens after threshold consecutive failures, skips calls for the cooldown period,
+// then lets one trial call through: success closes the breaker, failure opens it again.
+type Breaker struct {
+	mx        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     State
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	onChange  func(State)
+	now       func() time.Time
+}
+
+// New returns a closed breaker. onChange is called on every state change (may be nil).
+func New(threshold int, cooldown time.Duration, onChange func(State)) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a call may be made. Every allowed call must be followed by Success or Failure.
+func (b *Breaker) Allow() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(HalfOpen)
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Success registers a successful call
+func (b *Breaker) Success() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.failures = 0
+	b.probing = false
+	if b.state != Closed {
+		b.setState(Closed)
+	}
+}
+
+// Failure registers a failed call
+func (b *Breaker) Failure() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == HalfOpen || b.state == Closed && b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(Open)
+	}
+}
+
+// State returns current breaker state
+func (b *Breaker) State() State {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.state
+}
+
+func (b *Breaker) setState(state State) {
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
@@ -0,0 +1,47 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Breaker(t *testing.T) {
+	now := time.Now()
+	var states []State
+	b := New(3, 10*time.Second, func(s State) { states = append(states, s) })
+	b.now = func() time.Time { return now }
+
+	// failures below threshold and success in between
+	for _, ok := range []bool{false, false, true, false, false} {
+		assert.True(t, b.Allow())
+		if ok {
+			b.Success()
+		} else {
+			b.Failure()
+		}
+	}
+	assert.Equal(t, Closed, b.State())
+	// threshold reached
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+	// cooldown passed: one trial call
+	now = now.Add(10 * time.Second)
+	assert.True(t, b.Allow())
+	assert.Equal(t, HalfOpen, b.State())
+	assert.False(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.False(t, b.Allow())
+	// successful trial call
+	now = now.Add(10 * time.Second)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, Closed, b.State())
+	assert.True(t, b.Allow())
+
+	assert.Equal(t, []State{Open, HalfOpen, Open, HalfOpen, Closed}, states)
+}
@@ -0,0 +1,153 @@
+package catalog
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Function describes a database function
+type Function struct {
+	Name      string
+	Arguments string // argument list as in pg_get_function_arguments()
+	Result    string // result type as in pg_get_function_result()
+	Comment   string // COMMENT ON FUNCTION text
+}
+
+// Catalog keeps the list of functions available in database schemas.
+// Schemas are kept per database: db is a name of the connection the functions are loaded from
+// (databases may have schemas with the same name but different functions).
+type Catalog struct {
+	mx      sync.RWMutex
+	schemas map[source]map[string]Function // database and schema -> function name -> function
+	gen     uint64                         // incremented when a function list changes
+}
+
+type source struct {
+	db     string
+	schema string
+}
+
+// New returns an empty catalog
+func New() *Catalog {
+	return &Catalog{schemas: make(map[source]map[string]Function)}
+}
+
+// Load reads functions of the given schema from pg_proc
+func (c *Catalog) Load(conn *sql.DB, db string, schema string) error {
+	rows, err := conn.Query(`
+		select p.proname,
+			pg_get_function_arguments(p.oid),
+			coalesce(pg_get_function_result(p.oid), ''),
+			coalesce(obj_description(p.oid, 'pg_proc'), '')
+		from pg_proc p
+		join pg_namespace n on n.oid = p.pronamespace
+		where n.nspname = $1`, schema)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var funcs []Function
+	for rows.Next() {
+		var fn Function
+		if err = rows.Scan(&fn.Name, &fn.Arguments, &fn.Result, &fn.Comment); err != nil {
+			return err
+		}
+		funcs = append(funcs, fn)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	c.Set(db, schema, funcs)
+	return nil
+}
+
+// Set replaces function list of the given schema
+func (c *Catalog) Set(db string, schema string, funcs []Function) {
+	m := make(map[string]Function, len(funcs))
+	for _, fn := range funcs {
+		m[fn.Name] = fn
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	old, loaded := c.schemas[source{db, schema}]
+	if loaded && same(old, m) {
+		return
+	}
+	c.schemas[source{db, schema}] = m
+	c.gen++
+}
+
+// same returns true if function lists are equal
+func same(a map[string]Function, b map[string]Function) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, fn := range a {
+		if other, found := b[name]; !found || other != fn {
+			return false
+		}
+	}
+	return true
+}
+
+// Generation returns a number which changes whenever any function list changes
+func (c *Catalog) Generation() uint64 {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.gen
+}
+
+// Loaded returns true if the given schema has been loaded
+func (c *Catalog) Loaded(db string, schema string) bool {
+	c.mx.RLock()
+	_, found := c.schemas[source{db, schema}]
+	c.mx.RUnlock()
+	return found
+}
+
+// Exists returns true if the function exists in the given schema
+func (c *Catalog) Exists(db string, schema string, name string) bool {
+	c.mx.RLock()
+	_, found := c.schemas[source{db, schema}][name]
+	c.mx.RUnlock()
+	return found
+}
+
+// Functions returns functions of the given schema sorted by name
+func (c *Catalog) Functions(db string, schema string) []Function {
+	c.mx.RLock()
+	fns := c.schemas[source{db, schema}]
+	funcs := make([]Function, 0, len(fns))
+	for _, fn := range fns {
+		funcs = append(funcs, fn)
+	}
+	c.mx.RUnlock()
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].Name < funcs[j].Name })
+	return funcs
+}
+
+// Resolve returns the newest existing version of the function (name_vK, K <= version; version 1 has no suffix).
+// If the schema is not loaded or there is no such function at all, name_vN is returned as is.
+func (c *Catalog) Resolve(db string, schema string, name string, version int) (string, int) {
+	if c.Loaded(db, schema) {
+		for ver := version; ver > 0; ver-- {
+			fname := VersionedName(name, ver)
+			if c.Exists(db, schema, fname) {
+				return fname, ver
+			}
+		}
+	}
+	return VersionedName(name, version), version
+}
+
+// VersionedName returns function name with version suffix: foo_get, foo_get_v2, ...
+func VersionedName(name string, version int) string {
+	if version > 1 {
+		return name + "_v" + strconv.Itoa(version)
+	}
+	return name
+}
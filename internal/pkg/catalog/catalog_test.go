@@ -0,0 +1,62 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Resolve(t *testing.T) {
+	c := New()
+	// not loaded: legacy naming
+	name, ver := c.Resolve("main", "api", "foo_get", 3)
+	assert.Equal(t, "foo_get_v3", name)
+	assert.Equal(t, 3, ver)
+
+	c.Set("main", "api", []Function{{Name: "foo_get"}, {Name: "foo_get_v2"}, {Name: "bar_ins_v4"}})
+	// fallback to the newest existing version
+	name, ver = c.Resolve("main", "api", "foo_get", 3)
+	assert.Equal(t, "foo_get_v2", name)
+	assert.Equal(t, 2, ver)
+	// exact version
+	name, ver = c.Resolve("main", "api", "foo_get", 1)
+	assert.Equal(t, "foo_get", name)
+	assert.Equal(t, 1, ver)
+	// newer versions are never used
+	name, ver = c.Resolve("main", "api", "bar_ins", 2)
+	assert.Equal(t, "bar_ins_v2", name)
+	assert.Equal(t, 2, ver)
+	// other schema
+	name, _ = c.Resolve("main", "other", "foo_get", 3)
+	assert.Equal(t, "foo_get_v3", name)
+	// same schema in other database
+	c.Set("tenant", "api", []Function{{Name: "foo_get"}})
+	name, _ = c.Resolve("tenant", "api", "foo_get", 3)
+	assert.Equal(t, "foo_get", name)
+	assert.False(t, c.Loaded("shard", "api"))
+}
+
+func Test_Functions(t *testing.T) {
+	c := New()
+	c.Set("main", "api", []Function{{Name: "foo_get"}, {Name: "bar_ins", Comment: "creates bar"}})
+	funcs := c.Functions("main", "api")
+	assert.Equal(t, 2, len(funcs))
+	assert.Equal(t, "bar_ins", funcs[0].Name)
+	assert.Equal(t, "creates bar", funcs[0].Comment)
+	assert.Equal(t, 0, len(c.Functions("main", "other")))
+	// every change gives a new generation
+	gen := c.Generation()
+	c.Set("main", "api", []Function{{Name: "foo_get"}})
+	assert.NotEqual(t, gen, c.Generation())
+	gen = c.Generation()
+	c.Set("main", "api", []Function{{Name: "foo_get", Comment: "gets foo"}})
+	assert.NotEqual(t, gen, c.Generation())
+	// reload without changes
+	gen = c.Generation()
+	c.Set("main", "api", []Function{{Name: "foo_get", Comment: "gets foo"}})
+	assert.Equal(t, gen, c.Generation())
+	// empty schema is loaded
+	c.Set("main", "empty", nil)
+	assert.NotEqual(t, gen, c.Generation())
+	assert.True(t, c.Loaded("main", "empty"))
+}
@@ -6,20 +6,27 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"gopkg.in/yaml.v3"
 
+	"github.com/bhmj/pg-api/internal/pkg/jpath"
 	"github.com/bhmj/pg-api/internal/pkg/str"
 	"github.com/bhmj/pg-api/internal/pkg/tag"
 )
 
 var validServiceName *regexp.Regexp = regexp.MustCompile(`^[A-Za-z_\-]+$`)
+var schemaURLUnsafe *regexp.Regexp = regexp.MustCompile(`[^A-Za-z0-9_.]+`) // characters replaced in JSON Schema resource URLs
+var validTableName *regexp.Regexp = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*\.)?[A-Za-z_][A-Za-z0-9_]*$`)
 
 const (
 	contentTypeJSON    = "application/json"
@@ -28,6 +35,31 @@ const (
 	defaultConvention  = "CRUD"
 )
 
+// Enhance request body encodings
+const (
+	EncodingJSON = "json" // application/json
+	EncodingForm = "form" // application/x-www-form-urlencoded
+)
+
+// Enhance.ForEach element error handling
+const (
+	ElementSkip = "skip" // failed elements are left as is
+	ElementFail = "fail" // the step fails if any element fails
+)
+
+// Enhance step failure policies
+const (
+	OnErrorIgnore   = "ignore"   // the step result is skipped
+	OnErrorFallback = "fallback" // TransferFields Default values are embedded
+	OnErrorFail     = "fail"     // the request is aborted with ErrorStatus
+)
+
+// Enhance stages
+const (
+	StageRequest  = "request"  // enrich request body before the function call
+	StageResponse = "response" // enrich function result before it is returned
+)
+
 // HTTP defines server parameters
 type HTTP struct {
 	Endpoint    string   // API endpoint
@@ -55,13 +87,45 @@ type Minio struct {
 type Config struct {
 	HTTP    HTTP     // HTTP params + API endpoint
 	DBGroup struct { // Database connections
-		Read  Database // Read database params
-		Write Database // Write database params
+		Read     Database   // Read database params
+		Write    Database   // Write database params
+		Replicas []Database // Read replicas: missing params are taken from Read
+		Balance  struct {   // Read replicas balancing
+			Strategy    string  // round-robin (default) or least-conn
+			CheckPeriod int     // health check period in seconds (default is 5)
+			MaxLag      float64 // max replication lag in seconds (0 = unlimited)
+		}
+		Consistency struct { // read-your-writes
+			Enable bool   // return WAL position token after write calls, route reads with it accordingly
+			Header string // token header name (default is X-Read-After)
+			Cookie string // token cookie name (omittable)
+			TTL    int    // cookie lifetime in seconds (default is 60)
+		}
+		SlowQuery int // slow query log threshold in milliseconds (0 = off)
 	}
 	Cache struct { //
 		Enable bool
 		TTL    int
 	}
+	Tenants struct { // multi-tenant routing
+		Source   string   // tenant source: host, header or caller (key auth caller name)
+		Header   string   // header name for "header" source
+		MaxPools int      // max number of open tenant pools (0 = unlimited)
+		List     []Tenant //
+	}
+	Shards Shards   // horizontal sharding
+	Health struct { // readiness checks
+		CheckPeriod int // dependency check period in seconds (default is 5)
+		Failures    int // consecutive failures after which the service is not ready (default is 3)
+	}
+	Jobs     Jobs     // durable job queue
+	Shutdown struct { // graceful shutdown
+		PreStop  int // delay in seconds between readiness going false and closing the listener
+		Deadline int // max time in seconds to wait for running requests and background jobs (default is 5)
+	}
+	Catalog struct { // database function catalog
+		Refresh int // catalog reload period in seconds (0 = load once at startup)
+	}
 	Service struct {
 		Name       string
 		Version    string
@@ -91,14 +155,70 @@ type Config struct {
 
 // Database defines DB params
 type Database struct {
-	ConnString string
-	Host       string
-	Port       int
-	Name       string
-	User       string
-	Password   string
-	Schema     string
-	MaxConn    int
+	ConnString     string
+	Host           string
+	Port           int
+	Name           string
+	User           string
+	Password       string
+	Schema         string
+	MaxConn        int // max open connections
+	MinConn        int // min open connections kept by the pool
+	MaxLifetime    int // max connection lifetime in seconds (default is 3600)
+	HealthCheck    int // idle connections health check period in seconds (default is 60)
+	StatementCache int // prepared statements cache size per connection (default is 512)
+}
+
+// Tenant defines tenant database and/or schema
+type Tenant struct {
+	Name  string   // tenant name (used as metrics label)
+	Match []string // host names, header values or callers
+	Read  Database // read database params: if no ConnString and Host given, default connection is used
+	Write Database // write database params (missing params are taken from tenant Read)
+}
+
+// OwnDatabase returns true if the tenant has its own database connection params
+func (t *Tenant) OwnDatabase() bool {
+	return t.Read.ConnString != "" || t.Read.Host != ""
+}
+
+// Jobs defines durable job queue for background processing (finalize scenario)
+type Jobs struct {
+	Enable      bool   // store background jobs in the queue table instead of running them in memory
+	Table       string // queue table name, optionally schema-qualified (default is pgapi_jobs)
+	Workers     int    // number of workers per instance (default is 4)
+	MaxAttempts int    // attempts before the job goes to the dead letter state (default is 5)
+	Backoff     int    // delay in seconds before the second attempt, doubled for every next one (default is 1)
+	MaxBackoff  int    // max delay in seconds between attempts (default is 300)
+	Poll        int    // queue poll period in milliseconds (default is 1000)
+	Lease       int    // seconds a job is locked by a worker; after that it is retried by any instance (default is 60)
+	// client callbacks
+	CallbackHeader  string   // request header with client callback URL (default is X-Callback-URL)
+	CallbackHosts   []string // hosts allowed in client callback URLs (client callbacks are disabled if empty)
+	CallbackTimeout int      // callback request timeout in seconds (default is 10)
+}
+
+// Shards defines shard map
+type Shards struct {
+	Method string   // shard selection method: hash (default) or range
+	Key    ShardKey // shard key source
+	List   []Shard  //
+}
+
+// ShardKey defines where the shard key is taken from (sources are checked in this order)
+type ShardKey struct {
+	Path   string // URL path segment followed by the key ID, ex: "customer" for /customer/123/orders/
+	Header string // header name
+	Field  string // top-level body field name
+}
+
+// Shard defines shard database
+type Shard struct {
+	Name  string   // shard name
+	From  int64    // key range start (inclusive) for "range" method
+	To    int64    // key range end (exclusive, 0 = unbounded) for "range" method
+	Read  Database // read database params: missing params are taken from DBGroup.Read
+	Write Database // write database params: missing params are taken from shard Read
 }
 
 // MethodConfig defines methods
@@ -111,29 +231,108 @@ type MethodConfig struct {
 	Enhance      []Enhance    // enhance data using external service(s)
 	Postproc     []Enhance    // data postprocessing using external service(s)
 	HeadersPass  []HeaderPass // pass specified headers into proc
+	Deprecation  Deprecation  // deprecation signalling for old method versions
+	// JSON Schema (draft 2020-12): inline object or file path
+	RequestSchema  interface{} // request body schema
+	ResponseSchema interface{} // response schema (checked with LogLevel 3 only)
+	Limits         Limits      // request size limits
+	FanOut         string      // call the function on every shard and combine results: concat or merge
+	Webhook        string      // URL receiving finalize results (job queue only)
+	EnhanceBudget  int         // total time in milliseconds for all Enhance (or Postproc) steps of a call (0 = unlimited)
+	EnhanceThreads int         // max number of independent Enhance steps run in parallel (default is 4, 1 = sequential)
+	// function receiving Postproc results: (id, enriched JSON), called on the write pool
+	PostprocFunction string
 	// runtime
-	NameMatch []*regexp.Regexp // method mask(s) -- runtime
+	NameMatch         []*regexp.Regexp   // method mask(s) -- runtime
+	RequestValidator  *jsonschema.Schema `json:"-" yaml:"-"`
+	ResponseValidator *jsonschema.Schema `json:"-" yaml:"-"`
 }
 
 // Enhance methods
 type Enhance struct {
 	URL            string           // service URL
-	Method         string           // GET/POST
+	Method         string           // GET (default), POST, PUT, PATCH or DELETE
 	Condition      string           // Condition for invoking third-party service
 	IncomingFields []string         // fields from incoming query, jsonpath (ex: "$.nm_id")
 	ForwardFields  []string         // fields in forwarded query, plain text (ex: "ids")
 	TransferFields []TransferFields // response transfer: from received to target
 	InArray        bool             // if true, ForwardFields should be passed as an array
-	HeadersToSend  []struct {       //
-		Header string // incoming header
-		Value  string // JSON FIELD
-	}
+	HeadersToSend  []HeaderToSend   // headers of the call
+	BodyTemplate   interface{}      // request body with {$...} placeholders, used instead of ForwardFields (POST/PUT/PATCH)
+	Encoding       string           // request body encoding: json (default) or form
+	Stage          string           // request (default) or response; Postproc steps are always run on the response
+	Timeout        int              // call timeout in milliseconds (default is 1000 for Enhance, 60000 for background steps)
+	Retry          Retry            // retry policy
+	Breaker        Breaker          // circuit breaker per service host/path
+	Cache          EnhanceCache     // response cache
+	// array fan-out
+	ForEach       string // path to an array: the service is called per element (batched if InArray is set)
+	ElementErrors string // skip (default): failed elements are left as is; fail: the step fails if any element fails
+	// failure policy
+	OnError     string // ignore (default), fallback: embed TransferFields Default values, fail: abort the request
+	ErrorStatus int    // HTTP status of the request aborted by OnError fail: 502 (default), 503 or 424
+}
+
+// HeaderToSend defines a header of external service call
+type HeaderToSend struct {
+	Header string // header name
+	Value  string // header value, may contain {$...} placeholders of body fields
+	From   string // incoming header to take the value from (Value is used if it is missing)
+}
+
+// EnhanceCache defines external service response cache
+type EnhanceCache struct {
+	TTL         int // seconds a response is cached (0 = no cache); max-age of the response takes precedence
+	Size        int // max number of cached responses (default is 1000)
+	NotFoundTTL int // seconds a 404 response is cached (0 = not cached)
+}
+
+// Retry defines external service call retry policy
+type Retry struct {
+	Count      int   // number of retries (0 = single attempt)
+	Backoff    int   // delay in milliseconds before the first retry, doubled for every next one (default is 100)
+	MaxBackoff int   // max delay in milliseconds between retries (default is 2000)
+	Statuses   []int // HTTP statuses to retry (default is 502, 503, 504); network errors are always retried
+}
+
+// Breaker defines circuit breaker params
+type Breaker struct {
+	Failures int // consecutive failed calls to open the breaker (0 = no breaker)
+	Cooldown int // seconds the breaker stays open before a trial call (default is 30)
 }
 
 // TransferFields contains external service variable mapping
 type TransferFields struct {
-	From string // jsonpath, based on root
-	To   string // jsonpath, based on current node
+	From    string      // jsonpath, based on root
+	To      string      // target path ($.product.brand, $.items[*].price) or top-level field name
+	Mode    string      // set (default), append or merge
+	Default interface{} // value used when From yields nothing
+}
+
+// Limits defines request size limits (0 = unlimited)
+type Limits struct {
+	BodySize    int64 // max request body size, bytes
+	Depth       int   // max JSON nesting depth
+	ArrayLength int   // max JSON array length
+	Keys        int   // max number of keys in a JSON object
+}
+
+// Deprecation marks a range of method versions as deprecated
+type Deprecation struct {
+	VersionFrom int    // first deprecated version (default is 1)
+	VersionTo   int    // last deprecated version (deprecation is off if 0)
+	Date        string // deprecation date, YYYY-MM-DD or RFC3339 (omittable)
+	Sunset      string // sunset date, YYYY-MM-DD or RFC3339 (omittable)
+	Link        string // replacement link (omittable)
+	Gone        bool   // return 410 Gone after the sunset date
+	// runtime
+	DateTime   time.Time `json:"-" yaml:"-"`
+	SunsetTime time.Time `json:"-" yaml:"-"`
+}
+
+// Covers returns true if the given version is deprecated
+func (d *Deprecation) Covers(version int) bool {
+	return d.VersionTo > 0 && version >= d.VersionFrom && version <= d.VersionTo
 }
 
 // HeaderPass defines Header -> FieldName mapping
@@ -169,6 +368,80 @@ func (t *Config) validate() error {
 	if t.DBGroup.Read.MaxConn < 0 {
 		return fmt.Errorf("%s should be >= 0", t.getName("MaxConn"))
 	}
+	if err := validatePool("DBGroup.Read", t.DBGroup.Read); err != nil {
+		return err
+	}
+	write, _ := t.GetDBWrite()
+	if err := validatePool("DBGroup.Write", write); err != nil {
+		return err
+	}
+	for i, replica := range t.GetDBReplicas() {
+		if err := validatePool(fmt.Sprintf("DBGroup.Replicas[%d]", i), replica); err != nil {
+			return err
+		}
+	}
+
+	switch t.DBGroup.Balance.Strategy {
+	case "", "round-robin", "least-conn":
+	default:
+		return fmt.Errorf("DBGroup.Balance.Strategy should be round-robin or least-conn")
+	}
+	if t.DBGroup.Balance.CheckPeriod < 0 || t.DBGroup.Balance.MaxLag < 0 {
+		return fmt.Errorf("DBGroup.Balance.CheckPeriod and DBGroup.Balance.MaxLag should be >= 0")
+	}
+	if t.Shutdown.PreStop < 0 || t.Shutdown.Deadline < 0 {
+		return fmt.Errorf("Shutdown.PreStop and Shutdown.Deadline should be >= 0")
+	}
+	if err := validateJobs(&t.Jobs); err != nil {
+		return err
+	}
+	if t.Health.CheckPeriod < 0 || t.Health.Failures < 0 {
+		return fmt.Errorf("Health.CheckPeriod and Health.Failures should be >= 0")
+	}
+	if t.DBGroup.SlowQuery < 0 {
+		return fmt.Errorf("DBGroup.SlowQuery should be >= 0")
+	}
+
+	switch t.Tenants.Source {
+	case "", "host", "caller":
+	case "header":
+		if t.Tenants.Header == "" {
+			return fmt.Errorf("Tenants.Header is not specified")
+		}
+	default:
+		return fmt.Errorf("Tenants.Source should be host, header or caller")
+	}
+	for i, tn := range t.Tenants.List {
+		if tn.Name == "" || len(tn.Match) == 0 {
+			return fmt.Errorf("Tenants.List[%d]: Name and Match should be specified", i)
+		}
+	}
+
+	if err := validateShards(&t.Shards); err != nil {
+		return err
+	}
+
+	if l := t.General.Limits; l.BodySize < 0 || l.Depth < 0 || l.ArrayLength < 0 || l.Keys < 0 {
+		return fmt.Errorf("General.Limits should be >= 0")
+	}
+	if err := validateFanOut("General", t.General.FanOut); err != nil {
+		return err
+	}
+	if err := validateWebhook("General", t.General.Webhook, t.Jobs.Enable); err != nil {
+		return err
+	}
+	if err := validateEnhance("General", t.General.Postproc); err != nil {
+		return err
+	}
+	if err := validateQueued("General", t.General.Postproc, t.Jobs.Enable); err != nil {
+		return err
+	}
+	if err := validateFunction("General", t.General.PostprocFunction); err != nil {
+		return err
+	}
+	if t.General.EnhanceBudget < 0 || t.General.EnhanceThreads < 0 {
+		return fmt.Errorf("General.EnhanceBudget and General.EnhanceThreads should be >= 0")
+	}
 
 	if t.Service.Version == "" {
 		return fmt.Errorf("Service.Version is not specified")
@@ -194,12 +467,54 @@ func (t *Config) validate() error {
 	if err := validateEnhance("General", t.General.Enhance); err != nil {
 		return err
 	}
+	if err := validateDeprecation("General", &t.General.Deprecation); err != nil {
+		return err
+	}
+	if err := compileSchemas("General", &t.General); err != nil {
+		return err
+	}
 
 	for i, item := range t.Methods {
 
 		if err := validateEnhance(strings.Join(item.Name, ","), item.Enhance); err != nil {
 			return err
 		}
+		if err := validateDeprecation(strings.Join(item.Name, ","), &t.Methods[i].Deprecation); err != nil {
+			return err
+		}
+		if err := compileSchemas(strings.Join(item.Name, ","), &t.Methods[i]); err != nil {
+			return err
+		}
+		if l := item.Limits; l.BodySize < 0 || l.Depth < 0 || l.ArrayLength < 0 || l.Keys < 0 {
+			return fmt.Errorf("%s: Limits should be >= 0", strings.Join(item.Name, ","))
+		}
+		if err := validateFanOut(strings.Join(item.Name, ","), item.FanOut); err != nil {
+			return err
+		}
+		if err := validateWebhook(strings.Join(item.Name, ","), item.Webhook, t.Jobs.Enable); err != nil {
+			return err
+		}
+		if err := validateEnhance(strings.Join(item.Name, ","), item.Postproc); err != nil {
+			return err
+		}
+		queued := append([]Enhance{}, item.Postproc...)
+		if len(item.FinalizeName) > 0 {
+			// request steps of the finalize scenario are run in the background too
+			for _, enh := range append(append([]Enhance{}, t.General.Enhance...), item.Enhance...) {
+				if enh.Stage != StageResponse {
+					queued = append(queued, enh)
+				}
+			}
+		}
+		if err := validateQueued(strings.Join(item.Name, ","), queued, t.Jobs.Enable); err != nil {
+			return err
+		}
+		if err := validateFunction(strings.Join(item.Name, ","), item.PostprocFunction); err != nil {
+			return err
+		}
+		if item.EnhanceBudget < 0 || item.EnhanceThreads < 0 {
+			return fmt.Errorf("%s: EnhanceBudget and EnhanceThreads should be >= 0", strings.Join(item.Name, ","))
+		}
 
 		t.Methods[i].NameMatch = make([]*regexp.Regexp, len(item.Name))
 		for n, nm := range item.Name {
@@ -216,6 +531,9 @@ func (t *Config) validate() error {
 		}
 
 	}
+	if err := t.validateBreakers(); err != nil {
+		return err
+	}
 
 	if t.HTTP.UseSSL {
 		if t.HTTP.SSLCert == "" {
@@ -246,6 +564,88 @@ func validateEnhance(method string, enhs []Enhance) error {
 				return fmt.Errorf("%s: \"[]\" must be the only element in Enhance.ForwardFields", method)
 			}
 		}
+		switch enh.Method {
+		case "", "GET", "POST", "PUT", "PATCH", "DELETE":
+		default:
+			return fmt.Errorf("%s: Enhance.Method should be GET, POST, PUT, PATCH or DELETE", method)
+		}
+		bodyMethod := enh.Method == "POST" || enh.Method == "PUT" || enh.Method == "PATCH"
+		if enh.BodyTemplate != nil && !bodyMethod {
+			return fmt.Errorf("%s: Enhance.BodyTemplate requires POST, PUT or PATCH", method)
+		}
+		switch enh.Encoding {
+		case "", EncodingJSON:
+		case EncodingForm:
+			if _, ok := enh.BodyTemplate.(map[string]interface{}); enh.BodyTemplate != nil && !ok || enh.InArray || (len(enh.ForwardFields) > 0 && enh.ForwardFields[0] == "[]") {
+				return fmt.Errorf("%s: form Enhance.Encoding requires an object body", method)
+			}
+		default:
+			return fmt.Errorf("%s: Enhance.Encoding should be json or form", method)
+		}
+		for _, h := range enh.HeadersToSend {
+			if h.Header == "" {
+				return fmt.Errorf("%s: Enhance.HeadersToSend.Header is empty", method)
+			}
+		}
+		if enh.ForEach != "" {
+			if err := jpath.Validate(enh.ForEach); err != nil || !strings.HasPrefix(enh.ForEach, "$") || strings.Contains(enh.ForEach, "*") {
+				return fmt.Errorf("%s: Enhance.ForEach should be a path to an array without wildcards", method)
+			}
+			if enh.InArray && !bodyMethod {
+				return fmt.Errorf("%s: batched Enhance.ForEach (InArray) requires POST, PUT or PATCH", method)
+			}
+		}
+		switch enh.OnError {
+		case "", OnErrorIgnore, OnErrorFallback, OnErrorFail:
+		default:
+			return fmt.Errorf("%s: Enhance.OnError should be ignore, fallback or fail", method)
+		}
+		switch enh.ErrorStatus {
+		case 0, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusFailedDependency:
+		default:
+			return fmt.Errorf("%s: Enhance.ErrorStatus should be 502, 503 or 424", method)
+		}
+		switch enh.ElementErrors {
+		case "", ElementSkip, ElementFail:
+		default:
+			return fmt.Errorf("%s: Enhance.ElementErrors should be skip or fail", method)
+		}
+		switch enh.Stage {
+		case "", StageRequest, StageResponse:
+		default:
+			return fmt.Errorf("%s: Enhance.Stage should be request or response", method)
+		}
+		if enh.Timeout < 0 {
+			return fmt.Errorf("%s: Enhance.Timeout should be >= 0", method)
+		}
+		if enh.Retry.Count < 0 || enh.Retry.Backoff < 0 || enh.Retry.MaxBackoff < 0 {
+			return fmt.Errorf("%s: Enhance.Retry params should be >= 0", method)
+		}
+		for _, status := range enh.Retry.Statuses {
+			if status < 100 || status > 599 {
+				return fmt.Errorf("%s: invalid Enhance.Retry.Statuses value %d", method, status)
+			}
+		}
+		if enh.Breaker.Failures < 0 || enh.Breaker.Cooldown < 0 {
+			return fmt.Errorf("%s: Enhance.Breaker params should be >= 0", method)
+		}
+		if enh.Cache.TTL < 0 || enh.Cache.Size < 0 || enh.Cache.NotFoundTTL < 0 {
+			return fmt.Errorf("%s: Enhance.Cache params should be >= 0", method)
+		}
+		for _, tr := range enh.TransferFields {
+			if err := jpath.Validate(tr.To); err != nil {
+				return fmt.Errorf("%s: TransferFields.To: %s", method, err.Error())
+			}
+			switch tr.Mode {
+			case "", jpath.ModeSet, jpath.ModeAppend, jpath.ModeMerge:
+			default:
+				return fmt.Errorf("%s: TransferFields.Mode should be set, append or merge", method)
+			}
+			// From with [*] is set pairwise into the elements matched by the wildcard of To
+			if strings.Contains(tr.From, "[*]") && jpath.Wildcards(tr.To) > 1 {
+				return fmt.Errorf("%s: TransferFields.To \"%s\" must have one wildcard to be set pairwise", method, tr.To)
+			}
+		}
 		// TransferFields[:].From may contain references to ForwardFields[]
 		for _, tr := range enh.TransferFields {
 			for _, match := range rx.FindAllString(tr.From, -1) {
@@ -259,6 +659,201 @@ func validateEnhance(method string, enhs []Enhance) error {
 	return nil
 }
 
+func validateShards(sh *Shards) error {
+	switch sh.Method {
+	case "", "hash", "range":
+	default:
+		return fmt.Errorf("Shards.Method should be hash or range")
+	}
+	if len(sh.List) == 0 {
+		return nil
+	}
+	if sh.Key.Path == "" && sh.Key.Header == "" && sh.Key.Field == "" {
+		return fmt.Errorf("Shards.Key is not specified")
+	}
+	for i, shard := range sh.List {
+		if shard.Name == "" {
+			return fmt.Errorf("Shards.List[%d]: Name should be specified", i)
+		}
+		if sh.Method == "range" && shard.To != 0 && shard.From >= shard.To {
+			return fmt.Errorf("Shards.List[%d]: From >= To [%d >= %d]", i, shard.From, shard.To)
+		}
+	}
+	return nil
+}
+
+func validateJobs(j *Jobs) error {
+	if j.Workers < 0 || j.MaxAttempts < 0 || j.Backoff < 0 || j.MaxBackoff < 0 || j.Poll < 0 || j.Lease < 0 {
+		return fmt.Errorf("Jobs: Workers, MaxAttempts, Backoff, MaxBackoff, Poll and Lease should be >= 0")
+	}
+	if j.Table != "" && !validTableName.MatchString(j.Table) {
+		return fmt.Errorf("Jobs.Table: invalid table name \"%s\"", j.Table)
+	}
+	if j.CallbackTimeout < 0 {
+		return fmt.Errorf("Jobs.CallbackTimeout should be >= 0")
+	}
+	return nil
+}
+
+func validateWebhook(method string, webhook string, queue bool) error {
+	if webhook == "" {
+		return nil
+	}
+	if !queue {
+		return fmt.Errorf("%s: Webhook requires job queue (Jobs.Enable)", method)
+	}
+	u, err := url.Parse(webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: Webhook should be an absolute http(s) URL", method)
+	}
+	return nil
+}
+
+// validateQueued checks that steps run by the job queue do not take header values from the incoming request:
+// job payloads are stored in the database, so incoming headers (credentials) are not kept with the jobs
+func validateQueued(method string, enhs []Enhance, queue bool) error {
+	if !queue {
+		return nil
+	}
+	for _, enh := range enhs {
+		for _, h := range enh.HeadersToSend {
+			if h.From != "" {
+				return fmt.Errorf("%s: HeadersToSend.From can not be used in background steps with job queue (Jobs.Enable)", method)
+			}
+		}
+	}
+	return nil
+}
+
+// validateBreakers checks that steps calling the same service have the same Breaker settings
+// as they share one circuit breaker
+func (t *Config) validateBreakers() error {
+	breakers := make(map[string]Breaker)
+	check := func(enhs []Enhance) error {
+		for _, enh := range enhs {
+			if enh.Breaker.Failures == 0 {
+				continue
+			}
+			name := BreakerName(enh.URL)
+			if b, found := breakers[name]; found && b != enh.Breaker {
+				return fmt.Errorf("%s: Enhance.Breaker settings differ for the same service", name)
+			}
+			breakers[name] = enh.Breaker
+		}
+		return nil
+	}
+	if err := check(t.General.Enhance); err != nil {
+		return err
+	}
+	if err := check(t.General.Postproc); err != nil {
+		return err
+	}
+	for _, item := range t.Methods {
+		if err := check(item.Enhance); err != nil {
+			return err
+		}
+		if err := check(item.Postproc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BreakerName returns host and path of the service URL: http://domain.com/api/v1/?id=1 -> domain.com/api/v1/
+func BreakerName(serviceURL string) string {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return serviceURL
+	}
+	return u.Host + u.Path
+}
+
+func validateFunction(method string, name string) error {
+	if name != "" && !regexp.MustCompile(`^[A-Za-z_]\w*$`).MatchString(name) {
+		return fmt.Errorf("%s: PostprocFunction should be a function name without schema", method)
+	}
+	return nil
+}
+
+func validateFanOut(method string, fanOut string) error {
+	switch fanOut {
+	case "", "concat", "merge":
+		return nil
+	}
+	return fmt.Errorf("%s: FanOut should be concat or merge", method)
+}
+
+func validateDeprecation(method string, d *Deprecation) error {
+	if d.VersionTo == 0 {
+		return nil
+	}
+	if d.VersionFrom == 0 {
+		d.VersionFrom = 1
+	}
+	if d.VersionFrom > d.VersionTo {
+		return fmt.Errorf("%s: Deprecation.VersionFrom > Deprecation.VersionTo [%d > %d]", method, d.VersionFrom, d.VersionTo)
+	}
+	var err error
+	if d.DateTime, err = parseDate(d.Date); err != nil {
+		return fmt.Errorf("%s: invalid Deprecation.Date \"%s\"", method, d.Date)
+	}
+	if d.SunsetTime, err = parseDate(d.Sunset); err != nil {
+		return fmt.Errorf("%s: invalid Deprecation.Sunset \"%s\"", method, d.Sunset)
+	}
+	if d.Gone && d.Sunset == "" {
+		return fmt.Errorf("%s: Deprecation.Gone requires Deprecation.Sunset", method)
+	}
+	return nil
+}
+
+func compileSchemas(method string, mc *MethodConfig) (err error) {
+	if mc.RequestValidator, err = compileSchema(mc.RequestSchema, method+".request"); err != nil {
+		return fmt.Errorf("%s: RequestSchema: %s", method, err.Error())
+	}
+	if mc.ResponseValidator, err = compileSchema(mc.ResponseSchema, method+".response"); err != nil {
+		return fmt.Errorf("%s: ResponseSchema: %s", method, err.Error())
+	}
+	return nil
+}
+
+// compileSchema compiles JSON Schema given as an inline object, JSON string or file path
+func compileSchema(schema interface{}, name string) (*jsonschema.Schema, error) {
+	var doc []byte
+	var err error
+	switch v := schema.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if strings.HasPrefix(strings.TrimSpace(v), "{") {
+			doc = []byte(v)
+		} else if doc, err = ioutil.ReadFile(v); err != nil {
+			return nil, err
+		}
+	default:
+		if doc, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	url := "schema://" + schemaURLUnsafe.ReplaceAllString(name, "_") + ".json"
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	if err = c.AddResource(url, bytes.NewReader(doc)); err != nil {
+		return nil, err
+	}
+	return c.Compile(url)
+}
+
+// parseDate parses YYYY-MM-DD or RFC3339 date, empty string gives zero time
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 // MethodProperties returns completed MethodConfig for given version + method
 func (t *Config) MethodProperties(method string, version int) MethodConfig {
 
@@ -273,6 +868,15 @@ func (t *Config) MethodProperties(method string, version int) MethodConfig {
 	enhnc := t.General.Enhance
 	postpr := t.General.Postproc
 	hpass := t.General.HeadersPass
+	depr := t.General.Deprecation
+	reqSchema, reqValidator := t.General.RequestSchema, t.General.RequestValidator
+	respSchema, respValidator := t.General.ResponseSchema, t.General.ResponseValidator
+	limits := t.General.Limits
+	fanOut := t.General.FanOut
+	webhook := t.General.Webhook
+	budget := t.General.EnhanceBudget
+	threads := t.General.EnhanceThreads
+	postprocFn := t.General.PostprocFunction
 
 	// The best version number is the maximum one of all version numbers
 	// in t.Methods that are not greater than version number in HTTP request.
@@ -311,9 +915,45 @@ func (t *Config) MethodProperties(method string, version int) MethodConfig {
 		if len(bestMethod.HeadersPass) > 0 {
 			hpass = bestMethod.HeadersPass
 		}
+		if bestMethod.Deprecation.VersionTo > 0 {
+			depr = bestMethod.Deprecation
+		}
+		if bestMethod.RequestValidator != nil {
+			reqSchema, reqValidator = bestMethod.RequestSchema, bestMethod.RequestValidator
+		}
+		if bestMethod.ResponseValidator != nil {
+			respSchema, respValidator = bestMethod.ResponseSchema, bestMethod.ResponseValidator
+		}
+		limits.BodySize = int64(str.Icoalesce(int(bestMethod.Limits.BodySize), int(limits.BodySize)))
+		limits.Depth = str.Icoalesce(bestMethod.Limits.Depth, limits.Depth)
+		limits.ArrayLength = str.Icoalesce(bestMethod.Limits.ArrayLength, limits.ArrayLength)
+		limits.Keys = str.Icoalesce(bestMethod.Limits.Keys, limits.Keys)
+		fanOut = str.Scoalesce(bestMethod.FanOut, fanOut)
+		webhook = str.Scoalesce(bestMethod.Webhook, webhook)
+		budget = str.Icoalesce(bestMethod.EnhanceBudget, budget)
+		threads = str.Icoalesce(bestMethod.EnhanceThreads, threads)
+		postprocFn = str.Scoalesce(bestMethod.PostprocFunction, postprocFn)
 	}
 
-	return MethodConfig{FinalizeName: finName, Convention: conv, ContentType: ctype, Enhance: enhnc, Postproc: postpr, HeadersPass: hpass}
+	return MethodConfig{
+		FinalizeName:      finName,
+		Convention:        conv,
+		ContentType:       ctype,
+		Enhance:           enhnc,
+		Postproc:          postpr,
+		HeadersPass:       hpass,
+		Deprecation:       depr,
+		RequestSchema:     reqSchema,
+		ResponseSchema:    respSchema,
+		RequestValidator:  reqValidator,
+		ResponseValidator: respValidator,
+		Limits:            limits,
+		FanOut:            fanOut,
+		Webhook:           webhook,
+		EnhanceBudget:     budget,
+		EnhanceThreads:    threads,
+		PostprocFunction:  postprocFn,
+	}
 }
 
 type configType string
@@ -403,14 +1043,69 @@ func (t *Config) readIO(f io.Reader, fileType configType) error {
 
 // GetDBWrite returns config for write db and bool indicating it is the same db as read db
 func (t *Config) GetDBWrite() (Database, bool) {
-	v := Database{}
-	v.ConnString = str.Scoalesce(t.DBGroup.Write.ConnString, t.DBGroup.Read.ConnString)
-	v.Host = str.Scoalesce(t.DBGroup.Write.Host, t.DBGroup.Read.Host)
-	v.Port = str.Icoalesce(t.DBGroup.Write.Port, t.DBGroup.Read.Port)
-	v.Name = str.Scoalesce(t.DBGroup.Write.Name, t.DBGroup.Read.Name)
-	v.User = str.Scoalesce(t.DBGroup.Write.User, t.DBGroup.Read.User)
-	v.Password = str.Scoalesce(t.DBGroup.Write.Password, t.DBGroup.Read.Password)
-	v.Schema = str.Scoalesce(t.DBGroup.Write.Schema, t.DBGroup.Read.Schema)
-	v.MaxConn = str.Icoalesce(t.DBGroup.Write.MaxConn, t.DBGroup.Read.MaxConn)
+	v := coalesceDatabase(t.DBGroup.Write, t.DBGroup.Read)
 	return v, (v == t.DBGroup.Read)
 }
+
+// GetDBReplicas returns configs for read replicas
+func (t *Config) GetDBReplicas() []Database {
+	result := make([]Database, len(t.DBGroup.Replicas))
+	for i, r := range t.DBGroup.Replicas {
+		result[i] = coalesceDatabase(r, t.DBGroup.Read)
+	}
+	return result
+}
+
+// GetTenantDB returns read and write db configs for the tenant.
+// Tenants without own connection get default connection params with tenant schema.
+func (t *Config) GetTenantDB(tenant *Tenant) (read Database, write Database) {
+	if !tenant.OwnDatabase() {
+		read = coalesceDatabase(Database{Schema: tenant.Read.Schema}, t.DBGroup.Read)
+		write = coalesceDatabase(Database{Schema: str.Scoalesce(tenant.Write.Schema, tenant.Read.Schema)}, t.DBGroup.Write)
+		return
+	}
+	read = coalesceDatabase(tenant.Read, t.DBGroup.Read)
+	write = coalesceDatabase(tenant.Write, read)
+	return
+}
+
+// GetShardDB returns read and write db configs for the shard
+func (t *Config) GetShardDB(shard *Shard) (read Database, write Database) {
+	read = coalesceDatabase(shard.Read, t.DBGroup.Read)
+	write = coalesceDatabase(shard.Write, read)
+	return
+}
+
+// validatePool checks connection pool settings
+func validatePool(name string, d Database) error {
+	if d.MaxConn < 0 || d.MinConn < 0 || d.MaxLifetime < 0 || d.HealthCheck < 0 || d.StatementCache < 0 {
+		return fmt.Errorf("%s: MaxConn, MinConn, MaxLifetime, HealthCheck and StatementCache should be >= 0", name)
+	}
+	if d.MaxConn > 0 && d.MinConn > d.MaxConn {
+		return fmt.Errorf("%s: MinConn > MaxConn [%d > %d]", name, d.MinConn, d.MaxConn)
+	}
+	return nil
+}
+
+// coalesceDatabase returns db params with missing values taken from def.
+// Connection string is not inherited if the host is specified explicitly.
+func coalesceDatabase(db Database, def Database) Database {
+	v := Database{}
+	if db.Host == "" {
+		v.ConnString = str.Scoalesce(db.ConnString, def.ConnString)
+	} else {
+		v.ConnString = db.ConnString
+	}
+	v.Host = str.Scoalesce(db.Host, def.Host)
+	v.Port = str.Icoalesce(db.Port, def.Port)
+	v.Name = str.Scoalesce(db.Name, def.Name)
+	v.User = str.Scoalesce(db.User, def.User)
+	v.Password = str.Scoalesce(db.Password, def.Password)
+	v.Schema = str.Scoalesce(db.Schema, def.Schema)
+	v.MaxConn = str.Icoalesce(db.MaxConn, def.MaxConn)
+	v.MinConn = str.Icoalesce(db.MinConn, def.MinConn)
+	v.MaxLifetime = str.Icoalesce(db.MaxLifetime, def.MaxLifetime)
+	v.HealthCheck = str.Icoalesce(db.HealthCheck, def.HealthCheck)
+	v.StatementCache = str.Icoalesce(db.StatementCache, def.StatementCache)
+	return v
+}
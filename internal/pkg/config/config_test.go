@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -170,3 +171,418 @@ func Test_MethodProperties(t *testing.T) {
 	assert.Equal(t, err, nil)
 	cfg.MethodProperties("foo", 1)
 }
+
+func Test_Deprecation(t *testing.T) {
+	// From > To
+	err := validateDeprecation("foo", &Deprecation{VersionFrom: 3, VersionTo: 2})
+	assert.NotEqual(t, err, nil)
+	// invalid date
+	err = validateDeprecation("foo", &Deprecation{VersionTo: 2, Sunset: "tomorrow"})
+	assert.NotEqual(t, err, nil)
+	// Gone without Sunset
+	err = validateDeprecation("foo", &Deprecation{VersionTo: 2, Gone: true})
+	assert.NotEqual(t, err, nil)
+	// method settings override general ones
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"General":{"Deprecation":{"VersionTo":1}},
+		"Methods":[{"Name":["foo"], "Deprecation":{"VersionTo":4, "Sunset":"2030-01-01", "Gone":true}}]
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	props := cfg.MethodProperties("foo", 2)
+	assert.Equal(t, true, props.Deprecation.Covers(4))
+	assert.Equal(t, false, props.Deprecation.Covers(5))
+	assert.Equal(t, 2030, props.Deprecation.SunsetTime.Year())
+	props = cfg.MethodProperties("bar", 2)
+	assert.Equal(t, true, props.Deprecation.Covers(1))
+	assert.Equal(t, false, props.Deprecation.Covers(2))
+}
+
+func Test_Schema(t *testing.T) {
+	// invalid schema
+	_, err := compileSchema(map[string]interface{}{"type": 5}, "foo")
+	assert.NotEqual(t, err, nil)
+	// file not found
+	_, err = compileSchema("no_such_file.json", "foo")
+	assert.NotEqual(t, err, nil)
+	// inline string
+	sch, err := compileSchema(`{"type":"object"}`, "foo")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, sch, (*jsonschema.Schema)(nil))
+	// inline object, method schema overrides general one
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"General":{"RequestSchema":{"type":"object"}},
+		"Methods":[{"Name":["foo"], "RequestSchema":{"type":"object", "required":["id"]}}]
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, nil, cfg.MethodProperties("foo", 1).RequestValidator.Validate(map[string]interface{}{}))
+	assert.Equal(t, nil, cfg.MethodProperties("bar", 1).RequestValidator.Validate(map[string]interface{}{}))
+}
+
+func Test_Limits(t *testing.T) {
+	// negative limit
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"Methods":[{"Name":["foo"], "Limits":{"Depth":-1}}]
+	}`)
+	err := cfg.readIO(dummy, jsonConfig)
+	assert.NotEqual(t, err, nil)
+	// method limits override general ones field by field
+	cfg = New()
+	dummy = strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"General":{"Limits":{"BodySize":1000, "Depth":5}},
+		"Methods":[{"Name":["foo"], "Limits":{"BodySize":5000, "Keys":10}}]
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, Limits{BodySize: 5000, Depth: 5, Keys: 10}, cfg.MethodProperties("foo", 1).Limits)
+	assert.Equal(t, Limits{BodySize: 1000, Depth: 5}, cfg.MethodProperties("bar", 1).Limits)
+}
+
+func Test_GetDBReplicas(t *testing.T) {
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{
+			"Read":{"Host":"db", "Port":5432, "User":"foo", "Schema":"api"},
+			"Replicas":[{"Host":"replica1"}, {"Host":"replica2", "Port":6432}],
+			"Balance":{"Strategy":"least-conn"}
+		}
+	}`)
+	err := cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	replicas := cfg.GetDBReplicas()
+	assert.Equal(t, 2, len(replicas))
+	assert.Equal(t, Database{Host: "replica1", Port: 5432, User: "foo", Schema: "api"}, replicas[0])
+	assert.Equal(t, Database{Host: "replica2", Port: 6432, User: "foo", Schema: "api"}, replicas[1])
+	// invalid strategy
+	cfg = New()
+	dummy = strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Balance":{"Strategy":"random"}}
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.NotEqual(t, err, nil)
+}
+
+func Test_GetTenantDB(t *testing.T) {
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"Host":"db", "Port":5432, "User":"foo", "Schema":"api"}},
+		"Tenants":{"Source":"header", "Header":"X-Tenant", "List":[
+			{"Name":"acme", "Match":["acme"], "Read":{"Schema":"acme"}},
+			{"Name":"globex", "Match":["globex"], "Read":{"Host":"globex-db", "Schema":"globex"}}
+		]}
+	}`)
+	err := cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	// schema only
+	read, write := cfg.GetTenantDB(&cfg.Tenants.List[0])
+	assert.Equal(t, false, cfg.Tenants.List[0].OwnDatabase())
+	assert.Equal(t, Database{Host: "db", Port: 5432, User: "foo", Schema: "acme"}, read)
+	assert.Equal(t, "acme", write.Schema)
+	// own database
+	read, write = cfg.GetTenantDB(&cfg.Tenants.List[1])
+	assert.Equal(t, true, cfg.Tenants.List[1].OwnDatabase())
+	assert.Equal(t, Database{Host: "globex-db", Port: 5432, User: "foo", Schema: "globex"}, read)
+	assert.Equal(t, read, write)
+	// connection string is inherited only by tenants without own host
+	cfg.DBGroup.Read.ConnString = "host=db dbname=main"
+	read, _ = cfg.GetTenantDB(&cfg.Tenants.List[0])
+	assert.Equal(t, "host=db dbname=main", read.ConnString)
+	read, _ = cfg.GetTenantDB(&cfg.Tenants.List[1])
+	assert.Equal(t, "", read.ConnString)
+	assert.Equal(t, "globex-db", read.Host)
+	// header source without header name
+	cfg = New()
+	dummy = strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"Tenants":{"Source":"header", "List":[{"Name":"acme", "Match":["acme"]}]}
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.NotEqual(t, err, nil)
+}
+
+func Test_Shards(t *testing.T) {
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"Host":"db", "Port":5432, "User":"foo", "Schema":"api"}},
+		"Shards":{"Method":"range", "Key":{"Path":"customer"}, "List":[
+			{"Name":"s1", "To":1000, "Read":{"Host":"shard1"}},
+			{"Name":"s2", "From":1000, "Read":{"Host":"shard2"}, "Write":{"Port":6432}}
+		]},
+		"Methods":[{"Name":["^/customer/$"], "FanOut":"merge"}]
+	}`)
+	err := cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	read, write := cfg.GetShardDB(&cfg.Shards.List[1])
+	assert.Equal(t, Database{Host: "shard2", Port: 5432, User: "foo", Schema: "api"}, read)
+	assert.Equal(t, Database{Host: "shard2", Port: 6432, User: "foo", Schema: "api"}, write)
+	assert.Equal(t, "merge", cfg.MethodProperties("/customer/", 1).FanOut)
+	assert.Equal(t, "", cfg.MethodProperties("/order/", 1).FanOut)
+	// invalid ranges, missing key, invalid fan-out mode
+	for _, shards := range []string{
+		`"Shards":{"Method":"range", "Key":{"Header":"X-Customer"}, "List":[{"Name":"s1", "From":10, "To":5}]}`,
+		`"Shards":{"List":[{"Name":"s1"}]}`,
+		`"Methods":[{"Name":["foo"], "FanOut":"sum"}]`,
+	} {
+		cfg = New()
+		dummy = strings.NewReader(`{
+			"HTTP":{"Endpoint":"api", "Port":8080},
+			"Service":{"Version":"1.0.0", "Name":"dummy"},
+			` + shards + `
+		}`)
+		err = cfg.readIO(dummy, jsonConfig)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func Test_PoolSettings(t *testing.T) {
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{
+			"Read":{"Host":"db", "Schema":"api", "MaxConn":10, "MinConn":2, "MaxLifetime":600, "StatementCache":100},
+			"Write":{"Host":"primary", "MinConn":1}
+		}
+	}`)
+	err := cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	write, _ := cfg.GetDBWrite()
+	assert.Equal(t, Database{Host: "primary", Schema: "api", MaxConn: 10, MinConn: 1, MaxLifetime: 600, StatementCache: 100}, write)
+	// min > max
+	cfg = New()
+	dummy = strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"MaxConn":1, "MinConn":2}}
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.NotEqual(t, err, nil)
+	// write and replica settings are checked too
+	for _, group := range []string{
+		`{"Read":{"MaxConn":4}, "Write":{"MinConn":5}}`,
+		`{"Read":{"MaxConn":4}, "Replicas":[{"Host":"r1"}, {"Host":"r2", "MaxConn":2, "MinConn":3}]}`,
+		`{"Replicas":[{"Host":"r1", "StatementCache":-1}]}`,
+	} {
+		cfg = New()
+		dummy = strings.NewReader(`{
+			"HTTP":{"Endpoint":"api", "Port":8080},
+			"Service":{"Version":"1.0.0", "Name":"dummy"},
+			"DBGroup":` + group + `
+		}`)
+		err = cfg.readIO(dummy, jsonConfig)
+		assert.NotEqual(t, err, nil, group)
+	}
+}
+
+func Test_Jobs(t *testing.T) {
+	for _, tst := range []struct {
+		jobs  string
+		valid bool
+	}{
+		{`{"Enable":true}`, true},
+		{`{"Enable":true, "Table":"api.jobs", "Workers":8, "MaxAttempts":10}`, true},
+		{`{"Table":"jobs; drop table users"}`, false},
+		{`{"Table":"a.b.c"}`, false},
+		{`{"Workers":-1}`, false},
+		{`{"Lease":-60}`, false},
+	} {
+		cfg := New()
+		dummy := strings.NewReader(`{
+			"HTTP":{"Endpoint":"api", "Port":8080},
+			"Service":{"Version":"1.0.0", "Name":"dummy"},
+			"DBGroup":{"Read":{"Host":"db"}},
+			"Jobs":` + tst.jobs + `
+		}`)
+		err := cfg.readIO(dummy, jsonConfig)
+		assert.Equal(t, tst.valid, err == nil, tst.jobs)
+	}
+}
+
+func Test_Webhook(t *testing.T) {
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"Host":"db"}},
+		"Jobs":{"Enable":true},
+		"General":{"Webhook":"https://hooks.example.com/all"},
+		"Methods":[{"Name":["orders"], "VersionFrom":1, "Webhook":"http://orders.local/done"}]
+	}`)
+	err := cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "http://orders.local/done", cfg.MethodProperties("/orders/", 1).Webhook)
+	assert.Equal(t, "https://hooks.example.com/all", cfg.MethodProperties("/users/", 1).Webhook)
+	// relative URL
+	cfg = New()
+	dummy = strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"Host":"db"}},
+		"Jobs":{"Enable":true},
+		"Methods":[{"Name":["orders"], "VersionFrom":1, "Webhook":"/done"}]
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.NotEqual(t, err, nil)
+	// no job queue
+	cfg = New()
+	dummy = strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"Host":"db"}},
+		"Methods":[{"Name":["orders"], "VersionFrom":1, "Webhook":"http://orders.local/done"}]
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.NotEqual(t, err, nil)
+}
+
+func Test_QueuedHeaders(t *testing.T) {
+	for _, tst := range []struct {
+		jobs    string
+		methods string
+		valid   bool
+	}{
+		{`true`, `[{"Name":["orders"], "VersionFrom":1, "Postproc":[{"URL":"http://svc/", "HeadersToSend":[{"Header":"Authorization", "From":"Authorization"}]}]}]`, false},
+		{`false`, `[{"Name":["orders"], "VersionFrom":1, "Postproc":[{"URL":"http://svc/", "HeadersToSend":[{"Header":"Authorization", "From":"Authorization"}]}]}]`, true},
+		{`true`, `[{"Name":["orders"], "VersionFrom":1, "Postproc":[{"URL":"http://svc/", "HeadersToSend":[{"Header":"X-Id", "Value":"{$.id}"}]}]}]`, true},
+		{`true`, `[{"Name":["orders"], "FinalizeName":["orders_fin"], "VersionFrom":1, "Enhance":[{"URL":"http://svc/", "HeadersToSend":[{"Header":"Authorization", "From":"Authorization"}]}]}]`, false},
+		{`true`, `[{"Name":["orders"], "FinalizeName":["orders_fin"], "VersionFrom":1, "Enhance":[{"URL":"http://svc/", "Stage":"response", "HeadersToSend":[{"Header":"Authorization", "From":"Authorization"}]}]}]`, true},
+		{`true`, `[{"Name":["orders"], "VersionFrom":1, "Enhance":[{"URL":"http://svc/", "HeadersToSend":[{"Header":"Authorization", "From":"Authorization"}]}]}]`, true},
+	} {
+		cfg := New()
+		dummy := strings.NewReader(`{
+			"HTTP":{"Endpoint":"api", "Port":8080},
+			"Service":{"Version":"1.0.0", "Name":"dummy"},
+			"DBGroup":{"Read":{"Host":"db"}},
+			"Jobs":{"Enable":` + tst.jobs + `},
+			"Methods":` + tst.methods + `
+		}`)
+		err := cfg.readIO(dummy, jsonConfig)
+		assert.Equal(t, tst.valid, err == nil, tst.methods)
+	}
+}
+
+func Test_EnhanceRetry(t *testing.T) {
+	for _, tst := range []struct {
+		enhance string
+		valid   bool
+	}{
+		{`{"URL":"http://svc/", "Retry":{"Count":2, "Statuses":[500, 503]}, "Breaker":{"Failures":5}}`, true},
+		{`{"URL":"http://svc/", "Retry":{"Count":-1}}`, false},
+		{`{"URL":"http://svc/", "Retry":{"Statuses":[1000]}}`, false},
+		{`{"URL":"http://svc/", "Breaker":{"Cooldown":-1}}`, false},
+		{`{"URL":"http://svc/a?x=1", "Breaker":{"Failures":5}}, {"URL":"http://svc/a?x=2", "Breaker":{"Failures":3}}`, false},
+		{`{"URL":"http://svc/a", "Breaker":{"Failures":5}}, {"URL":"http://svc/a"}, {"URL":"http://svc/b", "Breaker":{"Failures":3}}`, true},
+		{`{"URL":"http://svc/", "Cache":{"TTL":60, "Size":100, "NotFoundTTL":10}}`, true},
+		{`{"URL":"http://svc/", "Cache":{"TTL":-1}}`, false},
+		{`{"URL":"http://svc/", "Stage":"response"}`, true},
+		{`{"URL":"http://svc/", "Stage":"after"}`, false},
+		{`{"URL":"http://svc/", "TransferFields":[{"From":"$.a", "To":"$.items[*].a", "Mode":"append", "Default":[]}]}`, true},
+		{`{"URL":"http://svc/", "TransferFields":[{"From":"$.a", "To":"$.items[x]"}]}`, false},
+		{`{"URL":"http://svc/", "TransferFields":[{"From":"$.a", "To":"a", "Mode":"replace"}]}`, false},
+		{`{"URL":"http://svc/", "TransferFields":[{"From":"$.a[*]", "To":"$.items[*].a"}]}`, true},
+		{`{"URL":"http://svc/", "TransferFields":[{"From":"$.a[*]", "To":"$.items[*].a[*].b"}]}`, false},
+		{`{"URL":"http://svc/{$.sku}", "ForEach":"$.items", "ElementErrors":"fail"}`, true},
+		{`{"URL":"http://svc/", "Method":"POST", "ForEach":"$.order.items", "InArray":true}`, true},
+		{`{"URL":"http://svc/", "ForEach":"$.items", "InArray":true}`, false},
+		{`{"URL":"http://svc/", "ForEach":"$.items[*].parts"}`, false},
+		{`{"URL":"http://svc/", "ForEach":"items"}`, false},
+		{`{"URL":"http://svc/", "ForEach":"$.items", "ElementErrors":"ignore"}`, false},
+		{`{"URL":"http://svc/", "Method":"PUT", "BodyTemplate":{"order":{"id":"{$.id}"}}, "HeadersToSend":[{"Header":"Authorization", "From":"Authorization"}]}`, true},
+		{`{"URL":"http://svc/", "Method":"PATCH", "Encoding":"form", "IncomingFields":["$.id"], "ForwardFields":["id"]}`, true},
+		{`{"URL":"http://svc/", "Method":"DELETE", "BodyTemplate":{"id":"{$.id}"}}`, false},
+		{`{"URL":"http://svc/", "Method":"HEAD"}`, false},
+		{`{"URL":"http://svc/", "Method":"POST", "Encoding":"form", "BodyTemplate":["{$.id}"]}`, false},
+		{`{"URL":"http://svc/", "Method":"POST", "Encoding":"xml"}`, false},
+		{`{"URL":"http://svc/", "HeadersToSend":[{"Value":"1"}]}`, false},
+		{`{"URL":"http://svc/", "OnError":"fail", "ErrorStatus":424}`, true},
+		{`{"URL":"http://svc/", "OnError":"fallback", "TransferFields":[{"From":"$.a", "To":"a", "Default":0}]}`, true},
+		{`{"URL":"http://svc/", "OnError":"retry"}`, false},
+		{`{"URL":"http://svc/", "OnError":"fail", "ErrorStatus":500}`, false},
+	} {
+		cfg := New()
+		dummy := strings.NewReader(`{
+			"HTTP":{"Endpoint":"api", "Port":8080},
+			"Service":{"Version":"1.0.0", "Name":"dummy"},
+			"DBGroup":{"Read":{"Host":"db"}},
+			"Methods":[{"Name":["orders"], "VersionFrom":1, "Enhance":[` + tst.enhance + `]}]
+		}`)
+		err := cfg.readIO(dummy, jsonConfig)
+		assert.Equal(t, tst.valid, err == nil, tst.enhance)
+	}
+}
+
+func Test_EnhanceTimeout(t *testing.T) {
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"Host":"db"}},
+		"General":{"EnhanceBudget":3000, "EnhanceThreads":2},
+		"Methods":[{"Name":["orders"], "VersionFrom":1, "EnhanceBudget":500, "Enhance":[{"URL":"http://svc/", "Timeout":200}]}]
+	}`)
+	err := cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	props := cfg.MethodProperties("/orders/", 1)
+	assert.Equal(t, 500, props.EnhanceBudget)
+	assert.Equal(t, 200, props.Enhance[0].Timeout)
+	assert.Equal(t, 3000, cfg.MethodProperties("/users/", 1).EnhanceBudget)
+	assert.Equal(t, 2, props.EnhanceThreads)
+	// negative values
+	for _, general := range []string{`{"EnhanceBudget":-1}`, `{"EnhanceThreads":-1}`, `{"Postproc":[{"URL":"http://svc/", "Timeout":-1}]}`} {
+		cfg = New()
+		dummy = strings.NewReader(`{
+			"HTTP":{"Endpoint":"api", "Port":8080},
+			"Service":{"Version":"1.0.0", "Name":"dummy"},
+			"DBGroup":{"Read":{"Host":"db"}},
+			"General":` + general + `
+		}`)
+		err = cfg.readIO(dummy, jsonConfig)
+		assert.NotEqual(t, err, nil, general)
+	}
+}
+
+func Test_PostprocFunction(t *testing.T) {
+	cfg := New()
+	dummy := strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"Host":"db"}},
+		"General":{"PostprocFunction":"postproc_save"},
+		"Methods":[{"Name":["orders"], "VersionFrom":1, "PostprocFunction":"orders_postproc", "Postproc":[{"URL":"http://svc/"}]}]
+	}`)
+	err := cfg.readIO(dummy, jsonConfig)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "orders_postproc", cfg.MethodProperties("/orders/", 1).PostprocFunction)
+	assert.Equal(t, "postproc_save", cfg.MethodProperties("/users/", 1).PostprocFunction)
+	// schema-qualified name
+	cfg = New()
+	dummy = strings.NewReader(`{
+		"HTTP":{"Endpoint":"api", "Port":8080},
+		"Service":{"Version":"1.0.0", "Name":"dummy"},
+		"DBGroup":{"Read":{"Host":"db"}},
+		"General":{"PostprocFunction":"api.postproc_save"}
+	}`)
+	err = cfg.readIO(dummy, jsonConfig)
+	assert.NotEqual(t, err, nil)
+}
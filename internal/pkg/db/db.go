@@ -1,23 +1,34 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+
 	"github.com/bhmj/pg-api/internal/pkg/config"
-	//	_ "github.com/jackc/pgx"
-	_ "github.com/lib/pq"
 )
 
 //Database constants
 const (
 	databaseConnectionTimeout = 10
-	databaseCheckMaxAttempts  = 120
-	databaseCheckSleepTime    = 5 * time.Second
+	unlimitedConns            = math.MaxInt32 // MaxConn = 0: no limit, as with database/sql
 )
 
-// SetupDatabase opens database
+// pgx pools behind database/sql handles
+var pools sync.Map // *sql.DB -> *pgxpool.Pool
+
+// SetupDatabase opens database connection pool.
+// Queries are run in extended protocol mode: prepared statements are cached per connection
+// by SQL text, json/jsonb and bytea results are transferred in binary format.
 func SetupDatabase(conf config.Database) (*sql.DB, error) {
 	connStr := conf.ConnString
 	if connStr == "" {
@@ -31,14 +42,61 @@ func SetupDatabase(conf config.Database) (*sql.DB, error) {
 			databaseConnectionTimeout,
 		)
 	}
-	db, err := sql.Open("postgres", connStr)
+	poolConfig, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
 		return nil, err
 	}
+	poolConfig.MaxConns = unlimitedConns
+	if conf.MaxConn > 0 {
+		poolConfig.MaxConns = int32(conf.MaxConn)
+	}
+	poolConfig.MinConns = int32(conf.MinConn)
+	if conf.MaxLifetime > 0 {
+		poolConfig.MaxConnLifetime = time.Duration(conf.MaxLifetime) * time.Second
+	}
+	if conf.HealthCheck > 0 {
+		poolConfig.HealthCheckPeriod = time.Duration(conf.HealthCheck) * time.Second
+	}
+	if conf.StatementCache > 0 {
+		poolConfig.ConnConfig.StatementCacheCapacity = conf.StatementCache
+	}
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
 
-	db.SetMaxOpenConns(conf.MaxConn)
-	db.SetMaxIdleConns(conf.MaxConn)
-	db.SetConnMaxLifetime(-1) // forever
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	db := stdlib.OpenDBFromPool(pool)
+	pools.Store(db, pool)
 
 	return db, nil
 }
+
+// Close closes database handle along with its connection pool
+func Close(db *sql.DB) error {
+	err := db.Close()
+	if pool, found := pools.Load(db); found {
+		pool.(*pgxpool.Pool).Close()
+		pools.Delete(db)
+	}
+	return err
+}
+
+// CopyFrom bulk loads rows into the table using COPY protocol through the pool behind the database handle.
+// Table name may be schema-qualified. Returns the number of rows copied.
+func CopyFrom(ctx context.Context, db *sql.DB, table string, columns []string, rows [][]interface{}) (int64, error) {
+	pool, found := pools.Load(db)
+	if !found {
+		return 0, errors.New("COPY requires a connection opened with SetupDatabase")
+	}
+	return pool.(*pgxpool.Pool).CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, pgx.CopyFromRows(rows))
+}
+
+// Stat returns statistics of the connection pool behind the database handle
+func Stat(db *sql.DB) (*pgxpool.Stat, bool) {
+	pool, found := pools.Load(db)
+	if !found {
+		return nil, false
+	}
+	return pool.(*pgxpool.Pool).Stat(), true
+}
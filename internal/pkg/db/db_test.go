@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+)
+
+func Test_CopyFrom(t *testing.T) {
+	ctx := context.Background()
+	// only connections opened with SetupDatabase have a pool behind them
+	conn, _ := sql.Open("pgx", "host=localhost")
+	defer conn.Close()
+	_, err := CopyFrom(ctx, conn, "api.items", []string{"id"}, [][]interface{}{{1}})
+	assert.NotEqual(t, nil, err)
+
+	connStr := os.Getenv("PGAPI_TEST_DB")
+	if connStr == "" {
+		t.Skip("PGAPI_TEST_DB is not set")
+	}
+	db, err := SetupDatabase(config.Database{ConnString: connStr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(db)
+	table := fmt.Sprintf("pgapi_copy_test_%d", time.Now().UnixNano())
+	if _, err = db.Exec("create table " + table + " (id int, doc jsonb)"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("drop table " + table)
+
+	n, err := CopyFrom(ctx, db, "public."+table, []string{"id", "doc"}, [][]interface{}{{1, `{"a":1}`}, {2, nil}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(2), n)
+	var count int
+	db.QueryRow("select count(*) from " + table + " where doc is not null").Scan(&count)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,17 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrorClass returns SQLSTATE class of the database error ("23" for unique_violation 23505 etc).
+// Errors not reported by the server (network, timeouts) get class "none".
+func ErrorClass(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && len(pgErr.Code) >= 2 {
+		return pgErr.Code[:2]
+	}
+	return "none"
+}
@@ -0,0 +1,16 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ErrorClass(t *testing.T) {
+	assert.Equal(t, "23", ErrorClass(&pgconn.PgError{Code: "23505"}))
+	assert.Equal(t, "42", ErrorClass(fmt.Errorf("query: %w", &pgconn.PgError{Code: "42883"})))
+	assert.Equal(t, "none", ErrorClass(errors.New("connection refused")))
+}
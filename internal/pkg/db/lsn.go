@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseLSN converts textual WAL position ("16/B374D848") into a number
+func ParseLSN(s string) (uint64, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid LSN: %s", s)
+	}
+	hi, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN: %s", s)
+	}
+	lo, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN: %s", s)
+	}
+	return hi<<32 | lo, nil
+}
+
+// ReplayLSN returns WAL position replayed by a replica (or current position of a primary)
+func ReplayLSN(ctx context.Context, db *sql.DB) (uint64, error) {
+	var lsn string
+	err := db.QueryRowContext(ctx, `select
+		case
+			when pg_is_in_recovery() then coalesce(pg_last_wal_replay_lsn(), '0/0')
+			else pg_current_wal_lsn()
+		end::text`).Scan(&lsn)
+	if err != nil {
+		return 0, err
+	}
+	return ParseLSN(lsn)
+}
+
+// CurrentLSN returns current WAL write position of the primary database
+func CurrentLSN(db *sql.DB) (string, error) {
+	var lsn string
+	err := db.QueryRow("select pg_current_wal_lsn()::text").Scan(&lsn)
+	return lsn, err
+}
@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/log"
+)
+
+// Balancing strategies
+const (
+	RoundRobin = "round-robin"
+	LeastConn  = "least-conn"
+)
+
+const (
+	replicaCheckTimeout = 2 * time.Second
+	replicaLSNTimeout   = 100 * time.Millisecond // on-demand WAL position check
+)
+
+// replication lag (zero on primary or if replica has replayed everything it received) and replayed WAL position
+const lagQuery = `select
+	case
+		when not pg_is_in_recovery() or pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() then 0
+		else coalesce(extract(epoch from now() - pg_last_xact_replay_timestamp()), 0)
+	end,
+	case
+		when pg_is_in_recovery() then coalesce(pg_last_wal_replay_lsn(), '0/0')
+		else pg_current_wal_lsn()
+	end::text`
+
+type replica struct {
+	name    string
+	db      *sql.DB
+	mx      sync.RWMutex
+	healthy bool
+	lag     time.Duration
+	lsn     uint64 // replayed WAL position
+}
+
+// Pool balances read queries across replicas and falls back to primary when no replica is healthy
+type Pool struct {
+	primary  *sql.DB
+	replicas []*replica
+	strategy string
+	maxLag   time.Duration
+	next     uint32
+	log      log.Logger
+	lsnOf    func(ctx context.Context, db *sql.DB) (uint64, error) // replayed WAL position query
+}
+
+// NewPool returns a read pool. Replicas are considered healthy until the first check.
+func NewPool(primary *sql.DB, replicas map[string]*sql.DB, strategy string, maxLag time.Duration, log log.Logger) *Pool {
+	p := &Pool{
+		primary:  primary,
+		strategy: strategy,
+		maxLag:   maxLag,
+		log:      log,
+		lsnOf:    ReplayLSN,
+	}
+	for name, db := range replicas {
+		p.replicas = append(p.replicas, &replica{name: name, db: db, healthy: true})
+	}
+	return p
+}
+
+// DB returns a database connection for a read query
+func (p *Pool) DB() *sql.DB {
+	if r := p.pick(0); r != nil {
+		return r.db
+	}
+	return p.primary
+}
+
+// Primary returns primary database connection
+func (p *Pool) Primary() *sql.DB {
+	return p.primary
+}
+
+// Replicas returns replica connections by name
+func (p *Pool) Replicas() map[string]*sql.DB {
+	m := make(map[string]*sql.DB, len(p.replicas))
+	for _, r := range p.replicas {
+		m[r.name] = r.db
+	}
+	return m
+}
+
+// DBAfter returns a database connection for a read query which must see all the changes
+// up to the given WAL position: a replica which has replayed past it or primary.
+// Replica positions known from the last health check are refreshed on demand if no replica has caught up.
+func (p *Pool) DBAfter(ctx context.Context, lsn uint64) *sql.DB {
+	if r := p.pick(lsn); r != nil {
+		return r.db
+	}
+	if len(p.replicas) > 0 {
+		p.refreshLSN(ctx, lsn)
+		if r := p.pick(lsn); r != nil {
+			return r.db
+		}
+	}
+	return p.primary
+}
+
+// pick returns a healthy replica which has replayed the WAL position using the balancing strategy
+func (p *Pool) pick(lsn uint64) *replica {
+	if p.strategy == LeastConn {
+		return p.leastConn(lsn)
+	}
+	return p.roundRobin(lsn)
+}
+
+// refreshLSN queries replayed WAL position of healthy replicas which are behind the given one
+func (p *Pool) refreshLSN(ctx context.Context, lsn uint64) {
+	ctx, cancel := context.WithTimeout(ctx, replicaLSNTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, r := range p.replicas {
+		if !r.isHealthy() || r.replayed(lsn) {
+			continue
+		}
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			if pos, err := p.lsnOf(ctx, r.db); err == nil {
+				r.mx.Lock()
+				if pos > r.lsn {
+					r.lsn = pos
+				}
+				r.mx.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) roundRobin(lsn uint64) *replica {
+	n := uint32(len(p.replicas))
+	if n == 0 {
+		return nil
+	}
+	start := atomic.AddUint32(&p.next, 1)
+	for i := uint32(0); i < n; i++ {
+		r := p.replicas[(start+i)%n]
+		if r.isHealthy() && r.replayed(lsn) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (p *Pool) leastConn(lsn uint64) *replica {
+	var best *replica
+	bestInUse := 0
+	for _, r := range p.replicas {
+		if !r.isHealthy() || !r.replayed(lsn) {
+			continue
+		}
+		inUse := r.db.Stats().InUse
+		if best == nil || inUse < bestInUse {
+			best, bestInUse = r, inUse
+		}
+	}
+	return best
+}
+
+func (r *replica) isHealthy() bool {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return r.healthy
+}
+
+func (r *replica) replayed(lsn uint64) bool {
+	if lsn == 0 {
+		return true
+	}
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return r.lsn >= lsn
+}
+
+// Run checks replicas periodically until the context is done
+func (p *Pool) Run(ctx context.Context, period time.Duration) {
+	if len(p.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		p.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check checks availability and replication lag of every replica
+func (p *Pool) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range p.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			p.checkReplica(ctx, r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) checkReplica(ctx context.Context, r *replica) {
+	ctx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+	defer cancel()
+
+	var lag float64
+	var lsnText string
+	err := r.db.QueryRowContext(ctx, lagQuery).Scan(&lag, &lsnText)
+	lsn, _ := ParseLSN(lsnText)
+	healthy := err == nil
+	lagDuration := time.Duration(lag * float64(time.Second))
+	if healthy && p.maxLag > 0 && lagDuration > p.maxLag {
+		healthy = false
+	}
+
+	r.mx.Lock()
+	changed := r.healthy != healthy
+	r.healthy = healthy
+	r.lag = lagDuration
+	if err == nil {
+		r.lsn = lsn
+	}
+	r.mx.Unlock()
+
+	if !changed {
+		return
+	}
+	switch {
+	case healthy:
+		p.log.L().Infof("replica %s is back in rotation", r.name)
+	case err != nil:
+		p.log.L().Errorf("replica %s is out of rotation: %s", r.name, err.Error())
+	default:
+		p.log.L().Errorf("replica %s is out of rotation: lag %s", r.name, lagDuration)
+	}
+}
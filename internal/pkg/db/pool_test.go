@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func open(t *testing.T) *sql.DB {
+	db, err := SetupDatabase(config.Database{ConnString: "host=localhost"})
+	assert.Equal(t, nil, err)
+	return db
+}
+
+func Test_Pool(t *testing.T) {
+	primary, r1, r2 := open(t), open(t), open(t)
+
+	// no replicas
+	p := NewPool(primary, nil, RoundRobin, 0, nil)
+	assert.Equal(t, primary, p.DB())
+
+	// round-robin
+	p = NewPool(primary, map[string]*sql.DB{"r1": r1, "r2": r2}, RoundRobin, 0, nil)
+	first, second := p.DB(), p.DB()
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, first, p.DB())
+
+	// unhealthy replica is skipped
+	p.replicas[0].healthy = false
+	healthy := p.replicas[1].db
+	assert.Equal(t, healthy, p.DB())
+	assert.Equal(t, healthy, p.DB())
+	p.strategy = LeastConn
+	assert.Equal(t, healthy, p.DB())
+
+	// primary if no replica is healthy
+	p.replicas[1].healthy = false
+	assert.Equal(t, primary, p.DB())
+}
+
+func Test_ParseLSN(t *testing.T) {
+	lsn, err := ParseLSN("16/B374D848")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0x16B374D848), lsn)
+	_, err = ParseLSN("16B374D848")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseLSN("x/1")
+	assert.NotEqual(t, nil, err)
+}
+
+func Test_DBAfter(t *testing.T) {
+	primary, r1, r2 := open(t), open(t), open(t)
+	p := NewPool(primary, map[string]*sql.DB{"r1": r1, "r2": r2}, RoundRobin, 0, nil)
+	p.lsnOf = func(ctx context.Context, db *sql.DB) (uint64, error) { return 0, errors.New("no connection") }
+	p.replicas[0].lsn = 100
+	p.replicas[1].lsn = 200
+	// only the replica which has replayed the position
+	ctx := context.Background()
+	assert.Equal(t, p.replicas[1].db, p.DBAfter(ctx, 150))
+	assert.Equal(t, p.replicas[1].db, p.DBAfter(ctx, 150))
+	p.strategy = LeastConn
+	assert.Equal(t, p.replicas[1].db, p.DBAfter(ctx, 150))
+	// primary if no replica has caught up
+	assert.Equal(t, primary, p.DBAfter(ctx, 300))
+
+	// positions are refreshed on demand
+	var checked int32
+	p.lsnOf = func(ctx context.Context, db *sql.DB) (uint64, error) {
+		atomic.AddInt32(&checked, 1)
+		if db == p.replicas[0].db {
+			return 400, nil
+		}
+		return 250, nil
+	}
+	assert.Equal(t, p.replicas[0].db, p.DBAfter(ctx, 300))
+	assert.Equal(t, int32(2), checked)
+	assert.Equal(t, uint64(250), p.replicas[1].lsn)
+	// no check while a replica is up to date
+	assert.Equal(t, p.replicas[0].db, p.DBAfter(ctx, 300))
+	assert.Equal(t, int32(2), checked)
+}
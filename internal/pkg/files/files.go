@@ -1,6 +1,7 @@
 package files
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -40,6 +41,7 @@ type fileService struct {
 type FileService interface {
 	UploadFile(w http.ResponseWriter, r *http.Request)
 	GetFile(w http.ResponseWriter, r *http.Request)
+	Ping(ctx context.Context) error
 }
 
 // NewFileService returns new file service interface
@@ -59,6 +61,22 @@ func NewFileService(cfg *config.Minio, db *sql.DB, log log.Logger, base string,
 	}, nil
 }
 
+// Ping checks storage availability. The client has no context-aware calls,
+// so the check returns when the context is done without waiting for the request.
+func (s *fileService) Ping(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.mcli.ListBuckets()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // GetFile returns a file
 func (s *fileService) GetFile(w http.ResponseWriter, r *http.Request) {
 	re := regexp.MustCompile(s.base + `/file/([^/]+)/(.*)`)
@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync"
@@ -15,21 +16,23 @@ import (
 // Server implements basic Kube-dispatched HTTP server
 type Server interface {
 	Run(cfg config.HTTP, log log.Logger)
-	Shutdown()
+	Shutdown(ctx context.Context)
 	HandleFunc(pattern string, handler http.HandlerFunc)
 	//
 	Ready()
 	NotReady()
+	SetReport(report func() interface{})
 }
 
 type server struct {
-	mx    sync.RWMutex
-	wg    sync.WaitGroup
-	ready bool
-	alive bool
-	mux   *http.ServeMux
-	srv   *http.Server
-	log   log.Logger
+	mx     sync.RWMutex
+	wg     sync.WaitGroup
+	ready  bool
+	alive  bool
+	report func() interface{} // readiness breakdown
+	mux    *http.ServeMux
+	srv    *http.Server
+	log    log.Logger
 }
 
 // NewServer returns an HTTP server
@@ -62,6 +65,7 @@ func (s *server) Run(cfg config.HTTP, log log.Logger) {
 	if err != nil {
 		s.log.L().Fatal("failed to create listener: ", err)
 	}
+	s.Animate() // k8s liveness probe
 
 	go func() {
 		if cfg.UseSSL {
@@ -74,8 +78,8 @@ func (s *server) Run(cfg config.HTTP, log log.Logger) {
 	println("listening at ", cfg.Port)
 }
 
-// Shutdown the server
-func (s *server) Shutdown() {
+// Shutdown stops accepting connections and waits for running handlers until the context is done
+func (s *server) Shutdown(ctx context.Context) {
 	s.mx.Lock()
 	s.alive = false // k8s liveness probe
 	s.ready = false // k8s readiness probe
@@ -83,15 +87,21 @@ func (s *server) Shutdown() {
 
 	s.srv.SetKeepAlivesEnabled(false)
 
-	// 5 sec timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer func() {
-		cancel()
-	}()
 	if err := s.srv.Shutdown(ctx); err != nil {
-		s.log.L().Fatal("server shutdown failed: ", err)
+		s.log.L().Error("server shutdown failed: ", err)
+	}
+	// handlers of hijacked connections are not waited by http.Server
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.log.L().Info("server shutdown ok")
+	case <-ctx.Done():
+		s.log.L().Error("server shutdown: handlers are still running")
 	}
-	s.log.L().Info("server shutdown ok")
 }
 
 func (s *server) IsReady() bool {
@@ -126,19 +136,36 @@ func (s *server) Animate() {
 	s.mx.Unlock()
 }
 
+// SetReport sets readiness breakdown reporter for /ready
+func (s *server) SetReport(report func() interface{}) {
+	s.mx.Lock()
+	s.report = report
+	s.mx.Unlock()
+}
+
 func (s *server) handleIsReady(w http.ResponseWriter, r *http.Request) {
-	if s.IsReady() {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		http.NotFound(w, r)
+	code := http.StatusOK
+	if !s.IsReady() {
+		code = http.StatusServiceUnavailable
+	}
+	s.mx.RLock()
+	report := s.report
+	s.mx.RUnlock()
+	if report == nil {
+		w.WriteHeader(code)
+		return
 	}
+	buf, _ := json.Marshal(report())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buf)
 }
 
 func (s *server) handleIsAlive(w http.ResponseWriter, r *http.Request) {
 	if s.IsAlive() {
 		w.WriteHeader(http.StatusOK)
 	} else {
-		http.NotFound(w, r)
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 }
 
@@ -150,7 +177,7 @@ func (s *server) HandleFunc(pattern string, handler http.HandlerFunc) {
 func (s *server) waitable(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		s.wg.Add(1)
+		defer s.wg.Done()
 		handler(w, r)
-		s.wg.Done()
 	}
 }
@@ -0,0 +1,123 @@
+package httpcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a cached response
+type Entry struct {
+	Status  int    // 200 or 404 (negative caching)
+	Body    []byte //
+	ETag    string // validator for conditional requests
+	Expires time.Time
+}
+
+// Cache is an LRU cache of external service responses.
+// Expired entries with ETag are kept for revalidation.
+type Cache struct {
+	mx    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	lru   *list.List // front is the most recently used
+	now   func() time.Time
+}
+
+type item struct {
+	key   string
+	entry Entry
+}
+
+// New returns a cache of the given size
+func New(size int) *Cache {
+	return &Cache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+		now:   time.Now,
+	}
+}
+
+// Key makes cache key from request method, URL and body
+func Key(method string, url string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + url + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns cached entry and whether it is fresh. Stale entry is returned only if it can be revalidated.
+func (c *Cache) Get(key string) (entry *Entry, fresh bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	el, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+	it := el.Value.(*item)
+	if c.now().Before(it.entry.Expires) {
+		c.lru.MoveToFront(el)
+		e := it.entry
+		return &e, true
+	}
+	if it.entry.ETag == "" {
+		c.lru.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	e := it.entry
+	return &e, false
+}
+
+// Put stores the entry for ttl. Entries with zero ttl are stored only if they have ETag.
+func (c *Cache) Put(key string, entry Entry, ttl time.Duration) {
+	if ttl <= 0 && entry.ETag == "" {
+		return
+	}
+	entry.Expires = c.now().Add(ttl)
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if el, found := c.items[key]; found {
+		el.Value.(*item).entry = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&item{key: key, entry: entry})
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, el.Value.(*item).key)
+	}
+}
+
+// Len returns number of cached entries
+func (c *Cache) Len() int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.lru.Len()
+}
+
+// TTL returns cache period of a response: max-age from Cache-Control or the default ttl.
+// Responses with "no-store" are not cached, "no-cache" ones are revalidated on every use.
+func TTL(h http.Header, ttl time.Duration) (time.Duration, bool) {
+	for _, directive := range strings.Split(strings.ToLower(h.Get("Cache-Control")), ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-store":
+			return 0, false
+		case directive == "no-cache":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			if sec, err := strconv.Atoi(directive[len("max-age="):]); err == nil && sec >= 0 {
+				ttl = time.Duration(sec) * time.Second
+			}
+		}
+	}
+	return ttl, true
+}
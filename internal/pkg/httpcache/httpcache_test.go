@@ -0,0 +1,58 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Cache(t *testing.T) {
+	now := time.Now()
+	c := New(2)
+	c.now = func() time.Time { return now }
+
+	c.Put("a", Entry{Status: 200, Body: []byte(`{"a":1}`)}, time.Minute)
+	c.Put("b", Entry{Status: 404}, time.Second)
+	c.Put("c", Entry{Status: 200, Body: []byte(`{"c":1}`), ETag: `"v1"`}, 0) // revalidate on every use
+	c.Put("d", Entry{Status: 200}, 0)                                        // not stored
+	assert.Equal(t, 2, c.Len())
+
+	e, fresh := c.Get("a")
+	assert.Nil(t, e) // evicted as the least recently used
+	e, fresh = c.Get("c")
+	assert.False(t, fresh)
+	assert.Equal(t, `"v1"`, e.ETag)
+	e, fresh = c.Get("b")
+	assert.True(t, fresh)
+	assert.Equal(t, 404, e.Status)
+
+	// expired entry without ETag is dropped
+	now = now.Add(2 * time.Second)
+	e, fresh = c.Get("b")
+	assert.Nil(t, e)
+	assert.False(t, fresh)
+	assert.Equal(t, 1, c.Len())
+}
+
+func Test_TTL(t *testing.T) {
+	for _, tst := range []struct {
+		cacheControl string
+		ttl          time.Duration
+		store        bool
+	}{
+		{"", time.Minute, true},
+		{"public, max-age=10", 10 * time.Second, true},
+		{"no-cache", 0, true},
+		{"no-store", 0, false},
+		{"max-age=abc", time.Minute, true},
+	} {
+		h := http.Header{}
+		h.Set("Cache-Control", tst.cacheControl)
+		ttl, store := TTL(h, time.Minute)
+		assert.Equal(t, tst.ttl, ttl, tst.cacheControl)
+		assert.Equal(t, tst.store, store, tst.cacheControl)
+	}
+	assert.NotEqual(t, Key("GET", "http://svc/", nil), Key("POST", "http://svc/", nil))
+}
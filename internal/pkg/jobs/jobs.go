@@ -0,0 +1,370 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/backoff"
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/log"
+	"github.com/bhmj/pg-api/internal/pkg/str"
+)
+
+// Job states
+const (
+	StatePending = "pending" // waiting for a worker (or for the next attempt)
+	StateRunning = "running" // locked by a worker until the lease expires
+	StateDone    = "done"    // completed successfully
+	StateDead    = "dead"    // failed MaxAttempts times (dead letter)
+)
+
+// defaults
+const (
+	defaultTable       = "pgapi_jobs"
+	defaultWorkers     = 4
+	defaultMaxAttempts = 5
+	defaultBackoff     = 1    // seconds
+	defaultMaxBackoff  = 300  // seconds
+	defaultPoll        = 1000 // milliseconds
+	defaultLease       = 60   // seconds
+)
+
+// ErrNotFound is returned by Status for unknown job ID
+var ErrNotFound = errors.New("job not found")
+
+// errLeaseExpired is the last error of a job which was not finished by a worker MaxAttempts times
+var errLeaseExpired = errors.New("lease expired")
+
+// Job is a queued task
+type Job struct {
+	ID       int64
+	Kind     string
+	Payload  []byte
+	Attempts int // including the current one
+}
+
+// Status is a job state as stored in the queue
+type Status struct {
+	Job
+	State     string
+	LastError string
+	Result    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Handler processes a job and returns its result
+type Handler func(ctx context.Context, job Job) (result string, err error)
+
+// FinishFunc is called when a job is done or dead. jobErr is the last error for dead jobs.
+type FinishFunc func(ctx context.Context, job Job, state string, result string, jobErr error)
+
+// Queue is a job queue stored in a PostgreSQL table.
+// Workers of any number of service instances take jobs with SELECT ... FOR UPDATE SKIP LOCKED.
+// A job is locked for the lease period which is extended while the handler is running:
+// jobs of a crashed instance are taken again when their lease expires, so every job is processed at least once.
+type Queue struct {
+	db          *sql.DB
+	table       string
+	workers     int
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	poll        time.Duration
+	lease       time.Duration
+	handlers    map[string]Handler
+	onFinish    FinishFunc
+	log         log.Logger
+	wake        chan struct{}
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// New returns a job queue
+func New(db *sql.DB, cfg config.Jobs, log log.Logger) *Queue {
+	return &Queue{
+		db:          db,
+		table:       str.Scoalesce(cfg.Table, defaultTable),
+		workers:     str.Icoalesce(cfg.Workers, defaultWorkers),
+		maxAttempts: str.Icoalesce(cfg.MaxAttempts, defaultMaxAttempts),
+		backoff:     time.Duration(str.Icoalesce(cfg.Backoff, defaultBackoff)) * time.Second,
+		maxBackoff:  time.Duration(str.Icoalesce(cfg.MaxBackoff, defaultMaxBackoff)) * time.Second,
+		poll:        time.Duration(str.Icoalesce(cfg.Poll, defaultPoll)) * time.Millisecond,
+		lease:       time.Duration(str.Icoalesce(cfg.Lease, defaultLease)) * time.Second,
+		handlers:    make(map[string]Handler),
+		log:         log,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Register sets a handler for the job kind. Must be called before Run.
+func (q *Queue) Register(kind string, h Handler) {
+	q.handlers[kind] = h
+}
+
+// OnFinish sets a function called when a job is done or dead. Must be called before Run.
+func (q *Queue) OnFinish(fn FinishFunc) {
+	q.onFinish = fn
+}
+
+// Init creates the queue table if it does not exist
+func (q *Queue) Init(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, fmt.Sprintf(`
+		create table if not exists %[1]s (
+			id           bigserial primary key,
+			kind         text not null,
+			payload      jsonb not null,
+			state        text not null default 'pending',
+			attempts     int not null default 0,
+			last_error   text,
+			result       text,
+			run_at       timestamptz not null default now(),
+			locked_until timestamptz,
+			created_at   timestamptz not null default now(),
+			updated_at   timestamptz not null default now()
+		);
+		create index if not exists %[2]s_ready on %[1]s (run_at) where state in ('pending', 'running');`,
+		q.table, indexName(q.table)))
+	return err
+}
+
+// Enqueue adds a job to the queue
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload interface{}) (id int64, err error) {
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	err = q.db.QueryRowContext(ctx,
+		"insert into "+q.table+" (kind, payload) values ($1, $2) returning id", kind, string(buf)).Scan(&id)
+	if err == nil {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return
+}
+
+// Status returns the job state by ID
+func (q *Queue) Status(ctx context.Context, id int64) (*Status, error) {
+	var lastError, result sql.NullString
+	st := &Status{}
+	err := q.db.QueryRowContext(ctx, `
+		select id, kind, payload::text, state, attempts, last_error, result, created_at, updated_at
+		from `+q.table+` where id = $1`, id).Scan(
+		&st.ID, &st.Kind, &st.Payload, &st.State, &st.Attempts, &lastError, &result, &st.CreatedAt, &st.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	st.LastError = lastError.String
+	st.Result = result.String
+	return st, nil
+}
+
+// Run creates the table and starts workers. Workers run until Stop is called or the context is done.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		err := q.Init(ctx)
+		if err == nil {
+			break
+		}
+		q.log.L().Errorf("job queue init: %s", err.Error())
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.stop:
+			return
+		case <-time.After(q.poll):
+		}
+	}
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker(ctx)
+	}
+}
+
+// Stop stops taking new jobs and waits for running ones until the context is done.
+// Jobs still running by then are taken again by any instance after their lease expires.
+func (q *Queue) Stop(ctx context.Context) {
+	close(q.stop)
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.log.L().Error("job queue: jobs are still running, they will be retried after lease expiration")
+	}
+}
+
+func (q *Queue) worker(ctx context.Context) {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.stop:
+			return
+		default:
+		}
+		job, err := q.claim(ctx)
+		if err != nil && err != sql.ErrNoRows {
+			q.log.L().Errorf("job queue: %s", err.Error())
+		}
+		if job != nil && job.dead {
+			q.finish(ctx, job, StateDead, "", errLeaseExpired)
+			continue
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.stop:
+				return
+			case <-q.wake:
+			case <-time.After(q.poll):
+			}
+			continue
+		}
+		q.process(ctx, job)
+	}
+}
+
+// claimedJob is a job taken by a worker
+type claimedJob struct {
+	Job
+	dead bool // lease expired on the last attempt: the job is moved to the dead letter state instead of running
+}
+
+// claim locks the next ready job: a pending one or a running one with expired lease.
+// A running job with expired lease which has no attempts left becomes dead.
+func (q *Queue) claim(ctx context.Context) (*claimedJob, error) {
+	job := &claimedJob{}
+	var state string
+	err := q.db.QueryRowContext(ctx, `
+		update `+q.table+` set
+			state = case when state = 'running' and attempts >= $2 then 'dead' else 'running' end,
+			attempts = case when state = 'running' and attempts >= $2 then attempts else attempts + 1 end,
+			last_error = case when state = 'running' and attempts >= $2 then $3 else last_error end,
+			locked_until = case when state = 'running' and attempts >= $2 then null else now() + make_interval(secs => $1) end,
+			updated_at = now()
+		where id = (
+			select id from `+q.table+`
+			where state = 'pending' and run_at <= now() or state = 'running' and locked_until < now()
+			order by run_at
+			limit 1
+			for update skip locked
+		)
+		returning id, kind, payload::text, attempts, state`, q.lease.Seconds(), q.maxAttempts, errLeaseExpired.Error()).Scan(
+		&job.ID, &job.Kind, &job.Payload, &job.Attempts, &state)
+	if err != nil {
+		return nil, err
+	}
+	job.dead = state == StateDead
+	if job.dead {
+		q.log.L().Errorf("job %d (%s) is dead after %d attempts: %s", job.ID, job.Kind, job.Attempts, errLeaseExpired.Error())
+	}
+	return job, nil
+}
+
+func (q *Queue) process(ctx context.Context, job *claimedJob) {
+	handler, found := q.handlers[job.Kind]
+	if !found {
+		q.fail(ctx, job, fmt.Errorf("unknown job kind %q", job.Kind))
+		return
+	}
+	stop := make(chan struct{})
+	go q.heartbeat(ctx, job, stop)
+	result, err := handler(ctx, job.Job)
+	close(stop)
+	if err != nil {
+		q.fail(ctx, job, err)
+		return
+	}
+	_, err = q.db.ExecContext(ctx, `
+		update `+q.table+` set state = 'done', result = $2, last_error = null, locked_until = null, updated_at = now()
+		where id = $1`, job.ID, result)
+	if err != nil {
+		q.log.L().Errorf("job %d: %s", job.ID, err.Error())
+		return
+	}
+	q.finish(ctx, job, StateDone, result, nil)
+}
+
+// heartbeat extends the job lease until stop is closed.
+// The lease is extended only for the current attempt, so a job taken by another worker is not affected.
+func (q *Queue) heartbeat(ctx context.Context, job *claimedJob, stop chan struct{}) {
+	ticker := time.NewTicker(q.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		_, err := q.db.ExecContext(ctx, `
+			update `+q.table+` set locked_until = now() + make_interval(secs => $3), updated_at = now()
+			where id = $1 and state = 'running' and attempts = $2`, job.ID, job.Attempts, q.lease.Seconds())
+		if err != nil {
+			q.log.L().Errorf("job %d: lease: %s", job.ID, err.Error())
+		}
+	}
+}
+
+// finish calls OnFinish function if set
+func (q *Queue) finish(ctx context.Context, job *claimedJob, state string, result string, jobErr error) {
+	if q.onFinish != nil {
+		q.onFinish(ctx, job.Job, state, result, jobErr)
+	}
+}
+
+// fail schedules the next attempt or moves the job to the dead letter state
+func (q *Queue) fail(ctx context.Context, job *claimedJob, jobErr error) {
+	state := StatePending
+	if job.Attempts >= q.maxAttempts {
+		state = StateDead
+		q.log.L().Errorf("job %d (%s) is dead after %d attempts: %s", job.ID, job.Kind, job.Attempts, jobErr.Error())
+	} else {
+		q.log.L().Warnf("job %d (%s), attempt %d: %s", job.ID, job.Kind, job.Attempts, jobErr.Error())
+	}
+	delay := backoff.Delay(job.Attempts, q.backoff, q.maxBackoff)
+	_, err := q.db.ExecContext(ctx, `
+		update `+q.table+` set
+			state = $2,
+			last_error = $3,
+			run_at = now() + make_interval(secs => $4),
+			locked_until = null,
+			updated_at = now()
+		where id = $1`, job.ID, state, jobErr.Error(), delay.Seconds())
+	if err != nil {
+		q.log.L().Errorf("job %d: %s", job.ID, err.Error())
+		return
+	}
+	if state == StateDead {
+		q.finish(ctx, job, StateDead, "", jobErr)
+	}
+}
+
+// indexName makes index name from table name: api.jobs -> api_jobs
+func indexName(table string) string {
+	b := []byte(table)
+	for i := range b {
+		if b[i] == '.' {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/bhmj/pg-api/internal/pkg/db"
+	"github.com/bhmj/pg-api/internal/pkg/log"
+)
+
+func Test_IndexName(t *testing.T) {
+	assert.Equal(t, "pgapi_jobs", indexName("pgapi_jobs"))
+	assert.Equal(t, "api_jobs", indexName("api.jobs"))
+}
+
+// testQueue returns a queue in a new table of the database from PGAPI_TEST_DB
+func testQueue(t *testing.T, cfg config.Jobs) *Queue {
+	connStr := os.Getenv("PGAPI_TEST_DB")
+	if connStr == "" {
+		t.Skip("PGAPI_TEST_DB is not set")
+	}
+	conn, err := db.SetupDatabase(config.Database{ConnString: connStr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := log.New(0)
+	cfg.Table = fmt.Sprintf("pgapi_jobs_test_%d", time.Now().UnixNano())
+	q := New(conn, cfg, logger)
+	if err = q.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("drop table " + cfg.Table)
+		db.Close(conn)
+	})
+	return q
+}
+
+func Test_Claim(t *testing.T) {
+	q := testQueue(t, config.Jobs{})
+	ctx := context.Background()
+	id1, _ := q.Enqueue(ctx, "foo", 1)
+	id2, _ := q.Enqueue(ctx, "foo", 2)
+
+	job, err := q.claim(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id1, job.ID)
+	assert.Equal(t, 1, job.Attempts)
+	job, _ = q.claim(ctx)
+	assert.Equal(t, id2, job.ID)
+	// both jobs are locked
+	_, err = q.claim(ctx)
+	assert.Equal(t, sql.ErrNoRows, err)
+	st, _ := q.Status(ctx, id1)
+	assert.Equal(t, StateRunning, st.State)
+}
+
+func Test_LeaseExpiry(t *testing.T) {
+	q := testQueue(t, config.Jobs{Lease: 1})
+	ctx := context.Background()
+	id, _ := q.Enqueue(ctx, "foo", 1)
+	q.claim(ctx)
+	_, err := q.claim(ctx)
+	assert.Equal(t, sql.ErrNoRows, err)
+	// taken again after the lease expires
+	time.Sleep(1100 * time.Millisecond)
+	job, err := q.claim(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id, job.ID)
+	assert.Equal(t, 2, job.Attempts)
+}
+
+func Test_Heartbeat(t *testing.T) {
+	q := testQueue(t, config.Jobs{Lease: 1})
+	ctx := context.Background()
+	// lease is extended while the handler is running
+	q.Register("bar", func(ctx context.Context, job Job) (string, error) {
+		time.Sleep(2500 * time.Millisecond)
+		return "ok", nil
+	})
+	id, _ := q.Enqueue(ctx, "bar", 1)
+	job, _ := q.claim(ctx)
+	assert.Equal(t, id, job.ID)
+	done := make(chan struct{})
+	go func() {
+		q.process(ctx, job)
+		close(done)
+	}()
+	time.Sleep(2 * time.Second)
+	_, err := q.claim(ctx)
+	assert.Equal(t, sql.ErrNoRows, err)
+	<-done
+	st, _ := q.Status(ctx, id)
+	assert.Equal(t, StateDone, st.State)
+	assert.Equal(t, "ok", st.Result)
+}
+
+func Test_DeadLetter(t *testing.T) {
+	q := testQueue(t, config.Jobs{MaxAttempts: 2, Lease: 1})
+	ctx := context.Background()
+	var dead []int64
+	q.OnFinish(func(ctx context.Context, job Job, state string, result string, jobErr error) {
+		if state == StateDead {
+			dead = append(dead, job.ID)
+		}
+	})
+	q.Register("foo", func(ctx context.Context, job Job) (string, error) {
+		return "", errors.New("failed")
+	})
+
+	// failed MaxAttempts times
+	id, _ := q.Enqueue(ctx, "foo", 1)
+	for i := 0; i < 2; i++ {
+		q.db.Exec("update "+q.table+" set run_at = now() where id = $1", id)
+		job, err := q.claim(ctx)
+		assert.Equal(t, nil, err)
+		q.process(ctx, job)
+	}
+	st, _ := q.Status(ctx, id)
+	assert.Equal(t, StateDead, st.State)
+	assert.Equal(t, "failed", st.LastError)
+	assert.Equal(t, []int64{id}, dead)
+
+	// lease expired on the last attempt
+	id, _ = q.Enqueue(ctx, "foo", 2)
+	q.claim(ctx)
+	time.Sleep(1100 * time.Millisecond)
+	job, _ := q.claim(ctx)
+	assert.Equal(t, false, job.dead)
+	time.Sleep(1100 * time.Millisecond)
+	job, err := q.claim(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, job.dead)
+	assert.Equal(t, 2, job.Attempts)
+	st, _ = q.Status(ctx, id)
+	assert.Equal(t, StateDead, st.State)
+	assert.Equal(t, errLeaseExpired.Error(), st.LastError)
+}
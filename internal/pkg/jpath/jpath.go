@@ -0,0 +1,264 @@
+package jpath
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Set modes
+const (
+	ModeSet    = "set"    // replace target value
+	ModeAppend = "append" // append to target array (arrays are concatenated)
+	ModeMerge  = "merge"  // deep-merge objects, other values are replaced
+)
+
+// segment is a path step: object key, array index or wildcard
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parse parses a path: $.a.b, $['a'][0], $.items[*].price. A string without leading $ is a top-level key.
+func parse(path string) ([]segment, error) {
+	if !strings.HasPrefix(path, "$") {
+		if path == "" {
+			return nil, errors.New("empty path")
+		}
+		return []segment{{key: path}}, nil
+	}
+	var segs []segment
+	for p := path[1:]; p != ""; {
+		switch {
+		case strings.HasPrefix(p, ".*"):
+			segs = append(segs, segment{wildcard: true})
+			p = p[2:]
+		case p[0] == '.':
+			end := strings.IndexAny(p[1:], ".[")
+			if end < 0 {
+				end = len(p) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("%s: empty key", path)
+			}
+			segs = append(segs, segment{key: p[1 : end+1]})
+			p = p[end+1:]
+		case p[0] == '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%s: unclosed bracket", path)
+			}
+			inner := p[1:end]
+			switch {
+			case inner == "*":
+				segs = append(segs, segment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				segs = append(segs, segment{key: inner[1 : len(inner)-1]})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("%s: invalid index %s", path, inner)
+				}
+				segs = append(segs, segment{index: i, isIndex: true})
+			}
+			p = p[end+1:]
+		default:
+			return nil, fmt.Errorf("%s: unexpected %q", path, p[0])
+		}
+	}
+	return segs, nil
+}
+
+// Set puts the value into the document by path creating missing objects, returns the updated document.
+// The value is set to every node matched by wildcards. The document is left as is where the path can not be set.
+func Set(doc interface{}, path string, value interface{}, mode string) (interface{}, error) {
+	segs, err := parse(path)
+	if err != nil {
+		return doc, err
+	}
+	return set(doc, segs, value, nil, mode)
+}
+
+// SetEach puts the values pairwise into the elements of the array matched by the only wildcard of the path:
+// the n-th value is set into the n-th element. The number of values must be equal to the number of elements.
+func SetEach(doc interface{}, path string, values []interface{}, mode string) (interface{}, error) {
+	segs, err := parse(path)
+	if err != nil {
+		return doc, err
+	}
+	if wildcards(segs) != 1 {
+		return doc, fmt.Errorf("%s: path must have one wildcard", path)
+	}
+	if values == nil {
+		values = []interface{}{}
+	}
+	return set(doc, segs, nil, values, mode)
+}
+
+// set puts the value into the node by path. If pairs is not nil, its elements are set into the elements matched by wildcard.
+func set(node interface{}, segs []segment, value interface{}, pairs []interface{}, mode string) (interface{}, error) {
+	if len(segs) == 0 {
+		return apply(node, value, mode), nil
+	}
+	seg, rest := segs[0], segs[1:]
+	switch {
+	case seg.wildcard:
+		switch n := node.(type) {
+		case []interface{}:
+			if pairs != nil && len(pairs) != len(n) {
+				return node, fmt.Errorf("%d values for %d elements", len(pairs), len(n))
+			}
+			for i := range n {
+				v := value
+				if pairs != nil {
+					v = pairs[i]
+				}
+				if err := setChild(n, i, rest, v, nil, mode); err != nil {
+					return node, err
+				}
+			}
+		case map[string]interface{}:
+			if pairs != nil {
+				return node, errors.New("values can be set pairwise into array elements only")
+			}
+			for k := range n {
+				if err := setKey(n, k, rest, value, nil, mode); err != nil {
+					return node, err
+				}
+			}
+		default:
+			if pairs != nil {
+				return node, errors.New("wildcard does not match an array")
+			}
+		}
+		return node, nil
+	case seg.isIndex:
+		n, ok := node.([]interface{})
+		if !ok || seg.index >= len(n) {
+			return node, fmt.Errorf("index [%d] is out of range", seg.index)
+		}
+		return node, setChild(n, seg.index, rest, value, pairs, mode)
+	}
+	if node == nil {
+		node = make(map[string]interface{})
+	}
+	n, ok := node.(map[string]interface{})
+	if !ok {
+		return node, fmt.Errorf("can not set key %q: not an object", seg.key)
+	}
+	return node, setKey(n, seg.key, rest, value, pairs, mode)
+}
+
+// setKey sets the object key by the rest of the path. The key is left untouched if nothing is set.
+func setKey(n map[string]interface{}, key string, rest []segment, value interface{}, pairs []interface{}, mode string) error {
+	child, err := set(n[key], rest, value, pairs, mode)
+	if err != nil || child == nil && len(rest) > 0 {
+		return err
+	}
+	n[key] = child
+	return nil
+}
+
+// setChild sets the array element by the rest of the path. The element is left untouched if nothing is set.
+func setChild(n []interface{}, i int, rest []segment, value interface{}, pairs []interface{}, mode string) error {
+	child, err := set(n[i], rest, value, pairs, mode)
+	if err != nil || child == nil && len(rest) > 0 {
+		return err
+	}
+	n[i] = child
+	return nil
+}
+
+// wildcards returns the number of wildcard segments
+func wildcards(segs []segment) int {
+	count := 0
+	for _, seg := range segs {
+		if seg.wildcard {
+			count++
+		}
+	}
+	return count
+}
+
+// Wildcards returns the number of wildcards in the path (0 for invalid path)
+func Wildcards(path string) int {
+	segs, err := parse(path)
+	if err != nil {
+		return 0
+	}
+	return wildcards(segs)
+}
+
+// apply combines existing value with the new one according to the mode
+func apply(existing interface{}, value interface{}, mode string) interface{} {
+	switch mode {
+	case ModeAppend:
+		var res []interface{}
+		switch e := existing.(type) {
+		case nil:
+		case []interface{}:
+			res = e
+		default:
+			res = []interface{}{e}
+		}
+		if arr, ok := value.([]interface{}); ok {
+			return append(res, arr...)
+		}
+		return append(res, value)
+	case ModeMerge:
+		return Merge(existing, value)
+	}
+	return value
+}
+
+// Merge deep-merges src object into dst object. Non-object values are replaced by src.
+func Merge(dst interface{}, src interface{}) interface{} {
+	d, ok1 := dst.(map[string]interface{})
+	s, ok2 := src.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return src
+	}
+	for k, v := range s {
+		d[k] = Merge(d[k], v)
+	}
+	return d
+}
+
+// Validate checks path syntax
+func Validate(path string) error {
+	_, err := parse(path)
+	return err
+}
+
+// Get returns the value by path without wildcards
+func Get(doc interface{}, path string) (interface{}, error) {
+	segs, err := parse(path)
+	if err != nil {
+		return nil, err
+	}
+	node := doc
+	for _, seg := range segs {
+		switch {
+		case seg.wildcard:
+			return nil, fmt.Errorf("%s: wildcards are not supported", path)
+		case seg.isIndex:
+			n, ok := node.([]interface{})
+			if !ok || seg.index >= len(n) {
+				return nil, fmt.Errorf("%s: index [%d] is out of range", path, seg.index)
+			}
+			node = n[seg.index]
+		default:
+			n, ok := node.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: %q is not found", path, seg.key)
+			}
+			if node, ok = n[seg.key]; !ok {
+				return nil, fmt.Errorf("%s: %q is not found", path, seg.key)
+			}
+		}
+	}
+	return node, nil
+}
@@ -0,0 +1,125 @@
+package jpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Parse(t *testing.T) {
+	segs, err := parse(`$.product['brand name'][2][*].x`)
+	assert.Nil(t, err)
+	assert.Equal(t, []segment{{key: "product"}, {key: "brand name"}, {index: 2, isIndex: true}, {wildcard: true}, {key: "x"}}, segs)
+	segs, err = parse("brand_name")
+	assert.Nil(t, err)
+	assert.Equal(t, []segment{{key: "brand_name"}}, segs)
+	for _, bad := range []string{"", "$.", "$[abc]", "$[1", "$x"} {
+		_, err = parse(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func Test_Set(t *testing.T) {
+	for _, tst := range []struct {
+		doc    string
+		path   string
+		value  string
+		mode   string
+		result string
+	}{
+		{`{"a":1}`, "b", `2`, "", `{"a":1,"b":2}`},
+		{`{"a":1}`, "$.product.brand", `"X"`, "", `{"a":1,"product":{"brand":"X"}}`},
+		{`{"items":[{"id":1},{"id":2}]}`, "$.items[*].price", `[10,20]`, "", `{"items":[{"id":1,"price":[10,20]},{"id":2,"price":[10,20]}]}`},
+		{`{"x":1}`, "$.items[*].price", `5`, "", `{"x":1}`},
+		{`{"x":1,"n":null}`, "$.n", `null`, "", `{"n":null,"x":1}`},
+		{`{"items":[{"id":1},{"id":2}]}`, "$.items[*].tag", `"new"`, "", `{"items":[{"id":1,"tag":"new"},{"id":2,"tag":"new"}]}`},
+		{`[{"id":1},{"id":2}]`, "$[1].name", `"b"`, "", `[{"id":1},{"id":2,"name":"b"}]`},
+		{`{"tags":["a"]}`, "$.tags", `["b","c"]`, ModeAppend, `{"tags":["a","b","c"]}`},
+		{`{"tag":"a"}`, "$.tag", `"b"`, ModeAppend, `{"tag":["a","b"]}`},
+		{`{"p":{"a":1,"n":{"x":1}}}`, "$.p", `{"b":2,"n":{"y":2}}`, ModeMerge, `{"p":{"a":1,"b":2,"n":{"x":1,"y":2}}}`},
+		{`{"a":1}`, "$", `{"b":2}`, ModeMerge, `{"a":1,"b":2}`},
+	} {
+		var doc, value interface{}
+		_ = json.Unmarshal([]byte(tst.doc), &doc)
+		_ = json.Unmarshal([]byte(tst.value), &value)
+		res, err := Set(doc, tst.path, value, tst.mode)
+		assert.Nil(t, err, tst.path)
+		buf, _ := json.Marshal(res)
+		assert.Equal(t, tst.result, string(buf), tst.path)
+	}
+	// errors
+	var doc interface{}
+	_ = json.Unmarshal([]byte(`[1,2]`), &doc)
+	_, err := Set(doc, "name", 1, "")
+	assert.NotNil(t, err)
+	_, err = Set(doc, "$[5]", 1, "")
+	assert.NotNil(t, err)
+	// the document is left as is
+	_ = json.Unmarshal([]byte(`{"x":1}`), &doc)
+	res, err := Set(doc, "$.a[0]", 1, "")
+	assert.NotNil(t, err)
+	buf, _ := json.Marshal(res)
+	assert.Equal(t, `{"x":1}`, string(buf))
+	res, err = Set(doc, "$.a.b[0]", 1, "")
+	assert.NotNil(t, err)
+	buf, _ = json.Marshal(res)
+	assert.Equal(t, `{"x":1}`, string(buf))
+}
+
+func Test_SetEach(t *testing.T) {
+	for _, tst := range []struct {
+		doc    string
+		path   string
+		values []interface{}
+		mode   string
+		result string
+	}{
+		{`{"items":[{"id":1},{"id":2}]}`, "$.items[*].price", []interface{}{10, 20}, "", `{"items":[{"id":1,"price":10},{"id":2,"price":20}]}`},
+		{`[{"id":1},{"id":2}]`, "$[*].tags", []interface{}{"a", "b"}, ModeAppend, `[{"id":1,"tags":["a"]},{"id":2,"tags":["b"]}]`},
+		{`{"o":{"items":[]}}`, "$.o.items[*].price", nil, "", `{"o":{"items":[]}}`},
+	} {
+		var doc interface{}
+		_ = json.Unmarshal([]byte(tst.doc), &doc)
+		res, err := SetEach(doc, tst.path, tst.values, tst.mode)
+		assert.Nil(t, err, tst.path)
+		buf, _ := json.Marshal(res)
+		assert.Equal(t, tst.result, string(buf), tst.path)
+	}
+	// errors
+	for _, tst := range []struct {
+		doc  string
+		path string
+	}{
+		{`{"items":[{"id":1},{"id":2}]}`, "$.items[*].price"}, // 3 values for 2 elements
+		{`{"x":1}`, "$.items[*].price"},                       // no array
+		{`{"items":{"a":{},"b":{}}}`, "$.items[*].price"},     // not an array
+		{`{"items":[[{}]]}`, "$.items[*][*].price"},           // two wildcards
+		{`{"items":[{"id":1}]}`, "$.items[0].price"},          // no wildcard
+	} {
+		var doc interface{}
+		_ = json.Unmarshal([]byte(tst.doc), &doc)
+		res, err := SetEach(doc, tst.path, []interface{}{1, 2, 3}, "")
+		assert.NotNil(t, err, tst.doc)
+		buf, _ := json.Marshal(res)
+		assert.Equal(t, tst.doc, string(buf))
+	}
+	assert.Equal(t, 1, Wildcards("$.items[*].price"))
+	assert.Equal(t, 2, Wildcards("$.items[*].*"))
+	assert.Equal(t, 0, Wildcards("$.items"))
+}
+
+func Test_Get(t *testing.T) {
+	var doc interface{}
+	_ = json.Unmarshal([]byte(`{"order":{"items":[{"id":1},{"id":2}]}}`), &doc)
+	v, err := Get(doc, "$.order.items[1].id")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), v)
+	v, err = Get(doc, "$.order.items")
+	assert.Nil(t, err)
+	assert.Len(t, v, 2)
+	for _, bad := range []string{"$.order.lines", "$.order.items[2]", "$.order.items[*].id", "$.order.items.id"} {
+		_, err = Get(doc, bad)
+		assert.NotNil(t, err, bad)
+	}
+}
@@ -0,0 +1,178 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bhmj/pg-api/internal/pkg/log"
+)
+
+const checkTimeout = 2 * time.Second
+
+// Check returns an error if the dependency is not available
+type Check func(ctx context.Context) error
+
+// Signal receives readiness changes
+type Signal interface {
+	Ready()
+	NotReady()
+}
+
+// Check states
+const (
+	StatusOK      = "ok"      // check passed
+	StatusFailing = "failing" // check failed but the number of consecutive failures is below the threshold
+	StatusDown    = "down"    // check has never passed or failed too many times in a row
+)
+
+type check struct {
+	name     string
+	fn       Check
+	ok       bool
+	failures int
+	err      string
+}
+
+// CheckState is a check result
+type CheckState struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Failures int    `json:"failures,omitempty"`
+}
+
+// Report is a readiness breakdown by dependency
+type Report struct {
+	Ready  bool                  `json:"ready"`
+	Checks map[string]CheckState `json:"checks"`
+}
+
+// Manager keeps service readiness based on dependency checks.
+// The service is ready when every check has passed and none has failed threshold times in a row.
+type Manager struct {
+	mx        sync.RWMutex
+	checks    []*check
+	threshold int
+	ready     bool
+	stopping  bool
+	signal    Signal
+	log       log.Logger
+}
+
+// New returns a lifecycle manager
+func New(signal Signal, threshold int, log log.Logger) *Manager {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Manager{signal: signal, threshold: threshold, log: log}
+}
+
+// AddCheck adds a dependency check
+func (m *Manager) AddCheck(name string, fn Check) {
+	m.mx.Lock()
+	m.checks = append(m.checks, &check{name: name, fn: fn})
+	m.mx.Unlock()
+}
+
+// Run performs the checks periodically until the context is done
+func (m *Manager) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		m.CheckAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll performs all the checks in parallel and updates readiness
+func (m *Manager) CheckAll(ctx context.Context) {
+	m.mx.RLock()
+	checks := m.checks
+	m.mx.RUnlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			errs[i] = c.fn(cctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	ready := !m.stopping
+	for i, c := range checks {
+		if errs[i] == nil {
+			if !c.ok {
+				m.log.L().Infof("check %s: ok", c.name)
+			}
+			c.ok, c.failures, c.err = true, 0, ""
+		} else {
+			c.failures++
+			c.err = errs[i].Error()
+			if c.ok && c.failures >= m.threshold {
+				c.ok = false
+				m.log.L().Errorf("check %s: %s", c.name, c.err)
+			}
+		}
+		ready = ready && c.ok
+	}
+	m.setReady(ready)
+}
+
+// Shutdown makes the service not ready for good
+func (m *Manager) Shutdown() {
+	m.mx.Lock()
+	m.stopping = true
+	m.setReady(false)
+	m.mx.Unlock()
+}
+
+// must be called under lock
+func (m *Manager) setReady(ready bool) {
+	if ready == m.ready {
+		return
+	}
+	m.ready = ready
+	if m.signal == nil {
+		return
+	}
+	if ready {
+		m.signal.Ready()
+	} else {
+		m.signal.NotReady()
+	}
+}
+
+// Ready returns current readiness
+func (m *Manager) Ready() bool {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	return m.ready
+}
+
+// Report returns readiness breakdown by dependency
+func (m *Manager) Report() Report {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	r := Report{Ready: m.ready, Checks: make(map[string]CheckState, len(m.checks))}
+	for _, c := range m.checks {
+		st := CheckState{Status: StatusOK, Error: c.err, Failures: c.failures}
+		if !c.ok {
+			st.Status = StatusDown
+		} else if c.failures > 0 {
+			st.Status = StatusFailing
+		}
+		r.Checks[c.name] = st
+	}
+	return r
+}
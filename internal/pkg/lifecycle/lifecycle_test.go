@@ -0,0 +1,58 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bhmj/pg-api/internal/pkg/log"
+)
+
+type signal struct{ ready bool }
+
+func (s *signal) Ready()    { s.ready = true }
+func (s *signal) NotReady() { s.ready = false }
+
+func Test_Manager(t *testing.T) {
+	lg, _ := log.New(0)
+	sig := &signal{}
+	m := New(sig, 2, lg)
+	var dbErr error
+	m.AddCheck("db", func(ctx context.Context) error { return dbErr })
+	m.AddCheck("catalog", func(ctx context.Context) error { return nil })
+
+	// not ready until the first check
+	assert.Equal(t, false, m.Ready())
+	assert.Equal(t, StatusDown, m.Report().Checks["db"].Status)
+
+	m.CheckAll(context.Background())
+	assert.Equal(t, true, m.Ready())
+	assert.Equal(t, true, sig.ready)
+
+	// single failure is tolerated
+	dbErr = errors.New("connection refused")
+	m.CheckAll(context.Background())
+	assert.Equal(t, true, m.Ready())
+	assert.Equal(t, StatusFailing, m.Report().Checks["db"].Status)
+
+	// repeated failures
+	m.CheckAll(context.Background())
+	assert.Equal(t, false, m.Ready())
+	assert.Equal(t, false, sig.ready)
+	rep := m.Report()
+	assert.Equal(t, CheckState{Status: StatusDown, Error: "connection refused", Failures: 2}, rep.Checks["db"])
+	assert.Equal(t, CheckState{Status: StatusOK}, rep.Checks["catalog"])
+
+	// recovery
+	dbErr = nil
+	m.CheckAll(context.Background())
+	assert.Equal(t, true, m.Ready())
+
+	// shutdown
+	m.Shutdown()
+	assert.Equal(t, false, m.Ready())
+	m.CheckAll(context.Background())
+	assert.Equal(t, false, m.Ready())
+}
@@ -0,0 +1,70 @@
+package limits
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+)
+
+type level struct {
+	array     bool // array or object
+	count     int  // array items or object keys
+	expectKey bool // next object token is a key
+}
+
+// CheckJSON scans JSON document and checks its nesting depth, array lengths
+// and object key counts against the limits. Zero limit means no limit.
+// Invalid JSON is not reported: it is not a subject of this check.
+func CheckJSON(doc []byte, l config.Limits) error {
+	if l.Depth == 0 && l.ArrayLength == 0 && l.Keys == 0 {
+		return nil
+	}
+	var stack []level
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+		delim, isDelim := tok.(json.Delim)
+		if isDelim && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			if n := len(stack); n > 0 && !stack[n-1].array {
+				stack[n-1].expectKey = true // object value completed
+			}
+			continue
+		}
+		if n := len(stack); n > 0 {
+			top := &stack[n-1]
+			switch {
+			case top.array:
+				top.count++
+				if l.ArrayLength > 0 && top.count > l.ArrayLength {
+					return fmt.Errorf("array length exceeds %d", l.ArrayLength)
+				}
+			case top.expectKey:
+				top.count++
+				if l.Keys > 0 && top.count > l.Keys {
+					return fmt.Errorf("number of object keys exceeds %d", l.Keys)
+				}
+				top.expectKey = false
+				continue
+			case !isDelim:
+				top.expectKey = true // scalar object value
+			}
+		}
+		if isDelim {
+			stack = append(stack, level{array: delim == '[', expectKey: delim == '{'})
+			if l.Depth > 0 && len(stack) > l.Depth {
+				return fmt.Errorf("JSON depth exceeds %d", l.Depth)
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package limits
+
+import (
+	"testing"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckJSON(t *testing.T) {
+	doc := []byte(`{"a": 1, "b": [1, 2, {"c": [[3]]}], "d": {"e": "f", "g": null}}`)
+	// no limits
+	assert.Equal(t, nil, CheckJSON(doc, config.Limits{}))
+	// depth: {} -> [] -> {} -> [] -> []
+	assert.Equal(t, nil, CheckJSON(doc, config.Limits{Depth: 5}))
+	assert.NotEqual(t, nil, CheckJSON(doc, config.Limits{Depth: 4}))
+	// array length
+	assert.Equal(t, nil, CheckJSON(doc, config.Limits{ArrayLength: 3}))
+	assert.NotEqual(t, nil, CheckJSON(doc, config.Limits{ArrayLength: 2}))
+	// keys per object
+	assert.Equal(t, nil, CheckJSON(doc, config.Limits{Keys: 3}))
+	assert.NotEqual(t, nil, CheckJSON(doc, config.Limits{Keys: 2}))
+	// not a JSON
+	assert.Equal(t, nil, CheckJSON([]byte(`{"a":`), config.Limits{Depth: 1}))
+}
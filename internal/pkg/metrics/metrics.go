@@ -1,16 +1,29 @@
 package metrics
 
 import (
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type tPrometheusStat struct {
-	errors  *prometheus.CounterVec
-	latency *prometheus.HistogramVec
+	errors     *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	deprecated *prometheus.CounterVec
+	// per-tenant
+	tenantErrors  *prometheus.CounterVec
+	tenantLatency *prometheus.HistogramVec
+	// database
+	dbErrors *prometheus.CounterVec
+	// external services
+	breakers       *prometheus.GaugeVec
+	externalErrors *prometheus.CounterVec
+	externalCache  *prometheus.CounterVec
+	namespace      string
 	sync.RWMutex
 }
 
@@ -19,6 +32,13 @@ var stat tPrometheusStat
 // Metrics implements Score function to store metrics
 type Metrics interface {
 	Score(method string, path string, scope string, begin time.Time, err *error)
+	Deprecated(path string, version int, caller string)
+	ScoreTenant(tenant string, method string, path string, begin time.Time, err *error)
+	DBError(path string, class string)
+	WatchPools(stats func() map[string]*pgxpool.Stat)
+	BreakerState(service string, state int)
+	ExternalError(path string, service string, reason string)
+	ExternalCache(path string, service string, result string)
 }
 
 // Score registers latency and error count
@@ -34,6 +54,64 @@ func (t *tPrometheusStat) Score(method string, path string, scope string, begin
 	t.latency.With(labels).Observe(time.Since(begin).Seconds())
 }
 
+// ScoreTenant registers latency and error count per tenant
+func (t *tPrometheusStat) ScoreTenant(tenant string, method string, path string, begin time.Time, err *error) {
+	labels := prometheus.Labels{
+		"tenant": tenant,
+		"method": method,
+		"path":   path,
+	}
+	if err != nil && *err != nil {
+		t.tenantErrors.With(labels).Add(1)
+	}
+	t.tenantLatency.With(labels).Observe(time.Since(begin).Seconds())
+}
+
+// Deprecated counts calls to deprecated method versions per caller
+func (t *tPrometheusStat) Deprecated(path string, version int, caller string) {
+	t.deprecated.With(prometheus.Labels{
+		"path":    path,
+		"version": strconv.Itoa(version),
+		"caller":  caller,
+	}).Add(1)
+}
+
+// DBError counts query errors by SQLSTATE class
+func (t *tPrometheusStat) DBError(path string, class string) {
+	t.dbErrors.With(prometheus.Labels{
+		"path":  path,
+		"class": class,
+	}).Add(1)
+}
+
+// BreakerState sets external service circuit breaker state: 0 = closed, 1 = half-open, 2 = open
+func (t *tPrometheusStat) BreakerState(service string, state int) {
+	t.breakers.With(prometheus.Labels{"service": service}).Set(float64(state))
+}
+
+// ExternalError counts failed external service calls by reason: timeout, budget, breaker or error
+func (t *tPrometheusStat) ExternalError(path string, service string, reason string) {
+	t.externalErrors.With(prometheus.Labels{
+		"path":    path,
+		"service": service,
+		"reason":  reason,
+	}).Add(1)
+}
+
+// ExternalCache counts external service response cache hits and misses
+func (t *tPrometheusStat) ExternalCache(path string, service string, result string) {
+	t.externalCache.With(prometheus.Labels{
+		"path":    path,
+		"service": service,
+		"result":  result,
+	}).Add(1)
+}
+
+// WatchPools exports connection pool statistics returned by the function on every scrape
+func (t *tPrometheusStat) WatchPools(stats func() map[string]*pgxpool.Stat) {
+	prometheus.MustRegister(newPoolCollector(t.namespace, stats))
+}
+
 // NewMetrics returns a Metrics instance
 func NewMetrics(service string, buckets []float64) Metrics {
 	labelNames := []string{"method", "path", "scope"}
@@ -42,6 +120,7 @@ func NewMetrics(service string, buckets []float64) Metrics {
 		buckets = defaultBuckets
 	}
 	return &tPrometheusStat{
+		namespace: strings.Replace(service, "-", "_", -1),
 		errors: newCounterFrom(prometheus.CounterOpts{
 			Namespace: strings.Replace(service, "-", "_", -1),
 			Name:      "error_count",
@@ -53,6 +132,42 @@ func NewMetrics(service string, buckets []float64) Metrics {
 			Help:      "Total duration of request in seconds",
 			Buckets:   buckets,
 		}, labelNames),
+		deprecated: newCounterFrom(prometheus.CounterOpts{
+			Namespace: strings.Replace(service, "-", "_", -1),
+			Name:      "deprecated_calls",
+			Help:      "Calls to deprecated method versions per caller",
+		}, []string{"path", "version", "caller"}),
+		tenantErrors: newCounterFrom(prometheus.CounterOpts{
+			Namespace: strings.Replace(service, "-", "_", -1),
+			Name:      "tenant_error_count",
+			Help:      "Error count per tenant",
+		}, []string{"tenant", "method", "path"}),
+		tenantLatency: newHistogramFrom(prometheus.HistogramOpts{
+			Namespace: strings.Replace(service, "-", "_", -1),
+			Name:      "tenant_request_latency",
+			Help:      "Duration of request per tenant in seconds",
+			Buckets:   buckets,
+		}, []string{"tenant", "method", "path"}),
+		dbErrors: newCounterFrom(prometheus.CounterOpts{
+			Namespace: strings.Replace(service, "-", "_", -1),
+			Name:      "db_error_count",
+			Help:      "Query error count per SQLSTATE class",
+		}, []string{"path", "class"}),
+		breakers: newGaugeFrom(prometheus.GaugeOpts{
+			Namespace: strings.Replace(service, "-", "_", -1),
+			Name:      "external_breaker_state",
+			Help:      "External service circuit breaker state: 0 = closed, 1 = half-open, 2 = open",
+		}, []string{"service"}),
+		externalErrors: newCounterFrom(prometheus.CounterOpts{
+			Namespace: strings.Replace(service, "-", "_", -1),
+			Name:      "external_error_count",
+			Help:      "Failed external service calls per reason: timeout, budget, breaker or error",
+		}, []string{"path", "service", "reason"}),
+		externalCache: newCounterFrom(prometheus.CounterOpts{
+			Namespace: strings.Replace(service, "-", "_", -1),
+			Name:      "external_cache_count",
+			Help:      "External service response cache lookups per result: hit or miss",
+		}, []string{"path", "service", "result"}),
 	}
 }
 
@@ -61,6 +176,11 @@ func newHistogramFrom(opts prometheus.HistogramOpts, labelNames []string) *prome
 	prometheus.MustRegister(hv)
 	return hv
 }
+func newGaugeFrom(opts prometheus.GaugeOpts, labelNames []string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(opts, labelNames)
+	prometheus.MustRegister(g)
+	return g
+}
 func newCounterFrom(opts prometheus.CounterOpts, labelNames []string) *prometheus.CounterVec {
 	co := prometheus.NewCounterVec(opts, labelNames)
 	prometheus.MustRegister(co)
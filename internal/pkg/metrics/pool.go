@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector exports pgxpool statistics of named connection pools
+type poolCollector struct {
+	stats           func() map[string]*pgxpool.Stat
+	total           *prometheus.Desc
+	acquired        *prometheus.Desc
+	idle            *prometheus.Desc
+	max             *prometheus.Desc
+	acquireCount    *prometheus.Desc
+	emptyAcquire    *prometheus.Desc
+	acquireDuration *prometheus.Desc
+}
+
+func newPoolCollector(namespace string, stats func() map[string]*pgxpool.Stat) *poolCollector {
+	labels := []string{"pool"}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db", name), help, labels, nil)
+	}
+	return &poolCollector{
+		stats:           stats,
+		total:           desc("total_connections", "Open connections"),
+		acquired:        desc("acquired_connections", "Connections in use"),
+		idle:            desc("idle_connections", "Idle connections"),
+		max:             desc("max_connections", "Max connections allowed by the pool"),
+		acquireCount:    desc("acquire_count", "Total number of connections acquired"),
+		emptyAcquire:    desc("empty_acquire_count", "Total number of acquires which waited for a connection because the pool was empty"),
+		acquireDuration: desc("acquire_duration_seconds", "Total time spent acquiring connections"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.total
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.max
+	ch <- c.acquireCount
+	ch <- c.emptyAcquire
+	ch <- c.acquireDuration
+}
+
+// Collect implements prometheus.Collector
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for pool, st := range c.stats() {
+		ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(st.TotalConns()), pool)
+		ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(st.AcquiredConns()), pool)
+		ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(st.IdleConns()), pool)
+		ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(st.MaxConns()), pool)
+		ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(st.AcquireCount()), pool)
+		ch <- prometheus.MustNewConstMetric(c.emptyAcquire, prometheus.CounterValue, float64(st.EmptyAcquireCount()), pool)
+		ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, st.AcquireDuration().Seconds(), pool)
+	}
+}
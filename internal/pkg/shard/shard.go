@@ -0,0 +1,101 @@
+package shard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+)
+
+// Select returns index of the shard for the key
+func Select(key string, sh config.Shards) (int, error) {
+	if len(sh.List) == 0 {
+		return 0, fmt.Errorf("no shards defined")
+	}
+	if sh.Method == "range" {
+		n, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid shard key %q", key)
+		}
+		for i, s := range sh.List {
+			if n >= s.From && (s.To == 0 || n < s.To) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no shard for key %d", n)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(len(sh.List))), nil
+}
+
+// Concat combines JSON results into an array: arrays are concatenated, other values are appended
+func Concat(results []string) (string, error) {
+	combined := make([]interface{}, 0)
+	for _, res := range results {
+		v, err := decode(res)
+		if err != nil {
+			return "", err
+		}
+		if arr, ok := v.([]interface{}); ok {
+			combined = append(combined, arr...)
+		} else if v != nil {
+			combined = append(combined, v)
+		}
+	}
+	buf, err := json.Marshal(combined)
+	return string(buf), err
+}
+
+// Merge combines JSON objects: nested objects are merged, arrays are concatenated,
+// scalar values of later results win
+func Merge(results []string) (string, error) {
+	var combined interface{}
+	for _, res := range results {
+		v, err := decode(res)
+		if err != nil {
+			return "", err
+		}
+		combined = merge(combined, v)
+	}
+	buf, err := json.Marshal(combined)
+	return string(buf), err
+}
+
+func merge(dst interface{}, src interface{}) interface{} {
+	switch s := src.(type) {
+	case map[string]interface{}:
+		d, ok := dst.(map[string]interface{})
+		if !ok {
+			return s
+		}
+		for k, v := range s {
+			d[k] = merge(d[k], v)
+		}
+		return d
+	case []interface{}:
+		if d, ok := dst.([]interface{}); ok {
+			return append(d, s...)
+		}
+		return s
+	case nil:
+		return dst
+	}
+	return src
+}
+
+func decode(doc string) (interface{}, error) {
+	if doc == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(doc)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
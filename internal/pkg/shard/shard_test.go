@@ -0,0 +1,45 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+)
+
+func Test_Select(t *testing.T) {
+	// hash: stable and within bounds
+	sh := config.Shards{List: []config.Shard{{Name: "a"}, {Name: "b"}, {Name: "c"}}}
+	i, err := Select("12345", sh)
+	assert.Equal(t, nil, err)
+	j, _ := Select("12345", sh)
+	assert.Equal(t, i, j)
+	assert.True(t, i >= 0 && i < 3)
+	// range
+	sh = config.Shards{Method: "range", List: []config.Shard{{Name: "a", To: 1000}, {Name: "b", From: 1000}}}
+	i, err = Select("999", sh)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, i)
+	i, _ = Select("1000", sh)
+	assert.Equal(t, 1, i)
+	_, err = Select("abc", sh)
+	assert.NotEqual(t, nil, err)
+	sh.List[0].From = 1
+	_, err = Select("0", sh)
+	assert.NotEqual(t, nil, err)
+}
+
+func Test_Concat(t *testing.T) {
+	res, err := Concat([]string{`[1,2]`, `[]`, `{"a":3}`, ``, `[4]`})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `[1,2,{"a":3},4]`, res)
+	_, err = Concat([]string{`[1`})
+	assert.NotEqual(t, nil, err)
+}
+
+func Test_Merge(t *testing.T) {
+	res, err := Merge([]string{`{"items":[1],"total":{"a":1},"x":1}`, `{"items":[2],"total":{"b":2},"x":2}`})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"items":[1,2],"total":{"a":1,"b":2},"x":2}`, res)
+}
@@ -0,0 +1,140 @@
+package steps
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+)
+
+// anyField is a wildcard field: the step may read or write anyField field
+const anyField = "*"
+
+var (
+	rxPath      = regexp.MustCompile(`^\$(?:\.(\w+)|\[['"]([^'"]+)['"]\])`) // $.field..., $['field']...
+	rxURLKey    = regexp.MustCompile(`{(\$.+?)}`)                           // {$.field}
+	rxCondField = regexp.MustCompile(`@(?:\.(\w+)|\[['"]([^'"]+)['"]\])`)   // @.field, @['field']
+)
+
+// Fields is a set of top-level body field names
+type Fields map[string]bool
+
+// Plan groups Enhance steps into levels. Steps of the same level do not depend on each other
+// and may run in parallel; levels must run one after another.
+// Step B depends on an earlier step A if B reads a field A writes, or writes a field A reads or writes.
+// Read fields are taken from IncomingFields, {$...} placeholders of URL, BodyTemplate and HeadersToSend and Condition,
+// written fields from TransferFields.To.
+func Plan(enhance []config.Enhance) [][]int {
+	reads := make([]Fields, len(enhance))
+	writes := make([]Fields, len(enhance))
+	level := make([]int, len(enhance))
+	var levels [][]int
+	for j, enh := range enhance {
+		reads[j], writes[j] = Reads(enh), Writes(enh)
+		for i := 0; i < j; i++ {
+			if level[i] >= level[j] && (conflict(writes[i], reads[j]) || conflict(writes[i], writes[j]) || conflict(reads[i], writes[j])) {
+				level[j] = level[i] + 1
+			}
+		}
+		if level[j] == len(levels) {
+			levels = append(levels, nil)
+		}
+		levels[level[j]] = append(levels[level[j]], j)
+	}
+	return levels
+}
+
+// Reads returns top-level body fields the step reads.
+// ForEach step reads its array only: IncomingFields, BodyTemplate (and URL and header keys for per-element calls) are element-relative.
+func Reads(enh config.Enhance) Fields {
+	f := make(Fields)
+	if enh.ForEach != "" {
+		f[topField(enh.ForEach)] = true
+	} else {
+		for _, in := range enh.IncomingFields {
+			if strings.HasPrefix(in, "$") {
+				f[topField(in)] = true
+			}
+		}
+		templateReads(enh.BodyTemplate, f)
+	}
+	if enh.ForEach == "" || enh.InArray {
+		keys := enh.URL
+		for _, h := range enh.HeadersToSend {
+			keys += h.Value
+		}
+		for _, key := range rxURLKey.FindAllStringSubmatch(keys, -1) {
+			f[topField(key[1])] = true
+		}
+	}
+	if enh.Condition != "" {
+		matches := rxCondField.FindAllStringSubmatch(enh.Condition, -1)
+		// root references and unrecognized paths may read anything
+		if strings.Contains(enh.Condition, "$") || len(matches) != strings.Count(enh.Condition, "@") {
+			f[anyField] = true
+		}
+		for _, m := range matches {
+			f[m[1]+m[2]] = true
+		}
+	}
+	return f
+}
+
+// templateReads adds fields of {$...} placeholders in body template
+func templateReads(tpl interface{}, f Fields) {
+	switch t := tpl.(type) {
+	case map[string]interface{}:
+		for _, v := range t {
+			templateReads(v, f)
+		}
+	case []interface{}:
+		for _, v := range t {
+			templateReads(v, f)
+		}
+	case string:
+		for _, key := range rxURLKey.FindAllStringSubmatch(t, -1) {
+			f[topField(key[1])] = true
+		}
+	}
+}
+
+// Writes returns top-level body fields the step writes. ForEach step writes into its array elements only.
+func Writes(enh config.Enhance) Fields {
+	f := make(Fields)
+	if enh.ForEach != "" {
+		f[topField(enh.ForEach)] = true
+		return f
+	}
+	for _, tr := range enh.TransferFields {
+		if strings.HasPrefix(tr.To, "$") {
+			f[topField(tr.To)] = true
+		} else {
+			f[tr.To] = true
+		}
+	}
+	return f
+}
+
+// topField returns top-level field of a jsonpath: $.a.b -> a, $['a'][0] -> a, $..a -> *
+func topField(path string) string {
+	m := rxPath.FindStringSubmatch(path)
+	if m == nil {
+		return anyField
+	}
+	return m[1] + m[2]
+}
+
+func conflict(a, b Fields) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	if a[anyField] || b[anyField] {
+		return true
+	}
+	for f := range a {
+		if b[f] {
+			return true
+		}
+	}
+	return false
+}
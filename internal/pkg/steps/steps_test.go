@@ -0,0 +1,74 @@
+package steps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bhmj/pg-api/internal/pkg/config"
+)
+
+func Test_TopField(t *testing.T) {
+	for path, field := range map[string]string{
+		"$.nm_id":          "nm_id",
+		"$.order.items[0]": "order",
+		"$['user id'].x":   "user id",
+		"$..id":            anyField,
+		"$":                anyField,
+		"$.*":              anyField,
+	} {
+		assert.Equal(t, field, topField(path), path)
+	}
+}
+
+func Test_Reads(t *testing.T) {
+	enh := config.Enhance{
+		URL:            "http://svc/api/{$.user_id}/",
+		Condition:      "@.status > 1 && @['kind'] == 'a'",
+		IncomingFields: []string{"$.nm_id", "~null", "const"},
+	}
+	assert.Equal(t, Fields{"user_id": true, "status": true, "kind": true, "nm_id": true}, Reads(enh))
+	enh.Condition = "@..status > 1"
+	assert.True(t, Reads(enh)[anyField])
+	// body template and headers
+	enh = config.Enhance{
+		BodyTemplate:  map[string]interface{}{"order": map[string]interface{}{"ids": []interface{}{"{$.id}"}, "title": "{$.name} {$['brand']}"}},
+		HeadersToSend: []config.HeaderToSend{{Header: "X-Trace", Value: "{$.trace}"}},
+	}
+	assert.Equal(t, Fields{"id": true, "name": true, "brand": true, "trace": true}, Reads(enh))
+}
+
+func Test_ForEach(t *testing.T) {
+	enh := config.Enhance{
+		URL:            "http://svc/api/{$.sku}/",
+		ForEach:        "$.items",
+		IncomingFields: []string{"$.sku"},
+		TransferFields: []config.TransferFields{{From: "$.price", To: "price"}},
+	}
+	assert.Equal(t, Fields{"items": true}, Reads(enh))
+	assert.Equal(t, Fields{"items": true}, Writes(enh))
+	enh.InArray = true
+	assert.Equal(t, Fields{"items": true, "sku": true}, Reads(enh))
+}
+
+func Test_Plan(t *testing.T) {
+	tr := func(to ...string) []config.TransferFields {
+		var res []config.TransferFields
+		for _, f := range to {
+			res = append(res, config.TransferFields{From: "$.x", To: f})
+		}
+		return res
+	}
+	enhance := []config.Enhance{
+		{IncomingFields: []string{"$.id"}, TransferFields: tr("brand")},                  // 0
+		{IncomingFields: []string{"$.id"}, TransferFields: tr("size")},                   // 1: independent
+		{IncomingFields: []string{"$.brand"}, TransferFields: tr("country")},             // 2: reads 0
+		{URL: "http://svc/{$.size}/"},                                                    // 3: reads 1
+		{IncomingFields: []string{"$.id"}, TransferFields: tr("size")},                   // 4: overwrites 1, read by 3
+		{IncomingFields: []string{"$.id"}, Condition: "@.country == 'RU'"},               // 5: reads 2
+		{IncomingFields: []string{"$.id"}, Condition: "$[0].x > 1", TransferFields: nil}, // 6: reads anything
+	}
+	assert.Equal(t, [][]int{{0, 1}, {2, 3}, {4, 5}, {6}}, Plan(enhance))
+	assert.Equal(t, [][]int(nil), Plan(nil))
+	assert.Equal(t, Fields{"product": true}, Writes(config.Enhance{TransferFields: tr("$.product.brand")}))
+}